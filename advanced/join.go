@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"time"
 
+	"gorm/sorting"
+
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
@@ -207,6 +209,7 @@ func leftJoinTest(db *gorm.DB) {
 	join4FilteringTest(db)
 	join4AggregationTest(db)
 	join4SortingTest(db)
+	join4DynamicSortingTest(db)
 }
 
 // LEFT JOIN is using for filtering parent by *child* condition
@@ -267,3 +270,34 @@ func join4SortingTest(db *gorm.DB) {
 	b, _ := json.MarshalIndent(&users, "", "  ")
 	fmt.Println(string(b))
 }
+
+// The sort order of join4SortingTest coming from a request, e.g. ?sort=-orders.created_at:nulls_last,name
+// Order(userInput) would run whatever the user sent, the sorting package only accepts columns of
+// User4JoinDemo and its Orders, and quotes them.
+func join4DynamicSortingTest(db *gorm.DB) {
+	sorter, err := sorting.New(db, &User4JoinDemo{})
+	if err != nil {
+		panic(err)
+	}
+	// users without orders have a NULL orders.created_at, last
+	byLatestOrder, err := sorter.Scope("-orders.created_at:nulls_last,name")
+	if err != nil {
+		panic(err)
+	}
+
+	var users []User4JoinDemo
+	if err := db.Model(&User4JoinDemo{}).
+		Joins("LEFT JOIN orders ON orders.user_id = users.id").
+		Select("users.*").
+		Scopes(byLatestOrder).
+		Find(&users).Error; err != nil {
+		panic(err)
+	}
+	for _, u := range users {
+		fmt.Println(u.ID, u.Name)
+	}
+
+	if _, err := sorter.Scope("name; DROP TABLE users"); err != nil {
+		fmt.Println(err)
+	}
+}
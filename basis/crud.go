@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"time"
 
+	"gorm/dbtime"
+
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
@@ -20,18 +22,24 @@ type User struct {
 	CreatedAt   time.Time `gorm:"autoCreateTime"`
 	UpdatedAt   time.Time `gorm:"autoUpdateTime"`
 	LastLoginAt time.Time `gorm:"index"`
+
+	// lifecycle of inactive users, see lifecycle.go
+	Stage          string `gorm:"size:16;not null;default:active;index"`
+	StageChangedAt time.Time
+	DeletedAt      gorm.DeletedAt `gorm:"index"`
 }
 
 func CrudTest() {
 	dsn := "db/crud.db"
 	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{
-		Logger: logger.Default.LogMode(logger.Info),
+		Logger:  logger.Default.LogMode(logger.Info),
+		NowFunc: dbtime.Now,
 	})
 	if err != nil {
 		fmt.Printf("Failed to open %s, %v\n", dsn, err)
 	}
 
-	if err = db.AutoMigrate(&User{}); err != nil {
+	if err = db.AutoMigrate(&User{}, &UserTransition{}); err != nil {
 		fmt.Println("failed to auto migrate, ", err)
 	}
 
@@ -39,11 +47,15 @@ func CrudTest() {
 	if err := db.Exec("DELETE FROM users").Error; err != nil {
 		fmt.Println("failed to clear users table: ", err)
 	}
+	if err := db.Exec("DELETE FROM user_transitions").Error; err != nil {
+		fmt.Println("failed to clear user_transitions table: ", err)
+	}
 
 	create(db)
 	read(db)
 	update(db)
 	delete(db)
+	lifecycleTest(db)
 }
 
 func create(db *gorm.DB) {
@@ -56,7 +68,7 @@ func create(db *gorm.DB) {
 }
 
 func createUsers(db *gorm.DB) {
-	now := time.Now()
+	now := db.NowFunc()
 	users := []User{
 		{
 			Name:        "Alice",
@@ -246,6 +258,7 @@ func update(db *gorm.DB) {
 		Phone:  "8760981263",
 		Age:    0,
 		Status: "inactive",
+		Stage:  StageActive,
 	}
 
 	result = db.Save(&u2)
@@ -268,10 +281,11 @@ func update(db *gorm.DB) {
 	}
 }
 
-// Since 'User' model does not have 'gorm.DeletedAt' field, Delete() is permanent and cannot be recoverd (hard delete)
+// Since 'User' model has a 'gorm.DeletedAt' field (for the lifecycle), Delete() would only set deleted_at
+// (soft delete), Unscoped().Delete() removes the rows for good (hard delete) as this demo always did
 func delete(db *gorm.DB) {
 	// delete by primary key
-	result := db.Delete(&User{}, 1) // equivalent to db.Where("id = ?", 1).Delete(&User{})
+	result := db.Unscoped().Delete(&User{}, 1) // equivalent to db.Unscoped().Where("id = ?", 1).Delete(&User{})
 	if result.Error != nil {
 		fmt.Println("failed to delete user, ", result.Error)
 	} else {
@@ -279,7 +293,7 @@ func delete(db *gorm.DB) {
 	}
 
 	// delete with condition
-	result = db.Where("status = ?", "inactive").Delete(&User{})
+	result = db.Unscoped().Where("status = ?", "inactive").Delete(&User{})
 	if result.Error != nil {
 		fmt.Println("failed to delete user, ", result.Error)
 	} else {
@@ -291,29 +305,11 @@ func delete(db *gorm.DB) {
 	if err := db.Where("Age > ?", 30).First(&u).Error; err != nil {
 		fmt.Println("failed to find the first user, ", err)
 	} else {
-		result := db.Delete(&u)
+		result := db.Unscoped().Delete(&u)
 		if result.Error != nil {
 			fmt.Println("failed to delete user, ", result.Error)
 		} else {
 			fmt.Println("rows deleted: ", result.RowsAffected)
 		}
 	}
-
-	// Delete users that haven't logged in during the last 30 days
-	if n, err := DeleteInactiveUsers(db); err != nil {
-		panic(fmt.Sprintf("failed to delete inactive users, %v\n", err))
-	} else {
-		fmt.Printf("%d rows deleted", n)
-	}
-}
-
-func DeleteInactiveUsers(db *gorm.DB) (int64, error) {
-	threshold := time.Now().AddDate(0, 0, -30)
-
-	result := db.Where("last_login_at < ?", threshold).Delete(&User{})
-	if err := result.Error; err != nil {
-		return 0, err
-	}
-
-	return result.RowsAffected, nil
 }
@@ -0,0 +1,238 @@
+// User lifecycle
+//
+// Users who stop logging in aren't deleted at once, a sweep moves them through stages:
+//
+// | Stage     | Entered                              | Left for                       |
+// | --------- | ------------------------------------ | ------------------------------ |
+// | active    | on sign up, or on login              | dormant                        |
+// | dormant   | no login for DormantAfter            | scheduled, or active on login  |
+// | scheduled | no login for ScheduleAfter           | deleted, or active on login    |
+// | deleted   | Grace after being scheduled          | -                              |
+//
+// A sweep moves a user by one stage at most, and deletion waits Grace from the scheduling, not from the
+// last login, so a scheduled user always has Grace to log in and come back, even after a long absence or
+// when the thresholds are lowered. Nothing is sent to the users: whoever wants to warn them reads the moves
+// from the LifecycleReport or from user_transitions.
+//
+// Inactivity counts from the last login, or from the sign up for users who never logged in:
+//
+// SELECT * FROM users WHERE stage = 'active' AND last_login_at < ? AND created_at < ? AND deleted_at IS NULL;
+//
+// Deleted users are soft deleted (User has a gorm.DeletedAt field) and can still be restored with Unscoped.
+// Every move, on a sweep or on login, is recorded in user_transitions.
+
+package basis
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Stages of User.Stage
+const (
+	StageActive    = "active"
+	StageDormant   = "dormant"
+	StageScheduled = "scheduled"
+	StageDeleted   = "deleted"
+)
+
+// UserTransition records a user moving from one stage to another.
+type UserTransition struct {
+	ID        uint      `gorm:"primaryKey"`
+	UserID    uint      `gorm:"not null;index"`
+	FromStage string    `gorm:"size:16;not null"`
+	ToStage   string    `gorm:"size:16;not null"`
+	Reason    string    `gorm:"size:32;not null"`
+	CreatedAt time.Time `gorm:"autoCreateTime"`
+}
+
+type Lifecycle struct {
+	DormantAfter  time.Duration // without login, active → dormant
+	ScheduleAfter time.Duration // without login, dormant → scheduled
+	Grace         time.Duration // scheduled → deleted, counted from the scheduling
+	DryRun        bool          // only report the moves
+}
+
+// DefaultLifecycle makes users dormant after a month without login and deletes them two weeks after the second month.
+var DefaultLifecycle = Lifecycle{
+	DormantAfter:  30 * 24 * time.Hour,
+	ScheduleAfter: 60 * 24 * time.Hour,
+	Grace:         14 * 24 * time.Hour,
+}
+
+// Move is a user changing stage on a sweep.
+type Move struct {
+	UserID      uint
+	Name        string
+	Email       string
+	From        string
+	To          string
+	LastLoginAt time.Time
+}
+
+type LifecycleReport struct {
+	Now    time.Time
+	DryRun bool
+	Moves  []Move // moves applied, or planned in a dry run
+}
+
+// step is one of the moves of a sweep, cond selects the users due for it.
+type step struct {
+	from, to, reason string
+	cond             func(*gorm.DB) *gorm.DB
+}
+
+func (l Lifecycle) steps(now time.Time) []step {
+	inactiveSince := func(cutoff time.Time) func(*gorm.DB) *gorm.DB {
+		return func(db *gorm.DB) *gorm.DB {
+			return db.Where("last_login_at < ? AND created_at < ?", cutoff, cutoff)
+		}
+	}
+	return []step{
+		{StageActive, StageDormant, "inactive", inactiveSince(now.Add(-l.DormantAfter))},
+		{StageDormant, StageScheduled, "inactive", inactiveSince(now.Add(-l.ScheduleAfter))},
+		{StageScheduled, StageDeleted, "grace period over", func(db *gorm.DB) *gorm.DB {
+			return db.Where("stage_changed_at < ?", now.Add(-l.Grace))
+		}},
+	}
+}
+
+// AdvanceLifecycle sweeps the users due for their next stage. With DryRun nothing is written and
+// the report lists the moves the sweep would make.
+// Each user moves in its own short transaction, a user logging in meanwhile is left out of the sweep.
+func AdvanceLifecycle(db *gorm.DB, l Lifecycle) (*LifecycleReport, error) {
+	if l.DormantAfter <= 0 || l.ScheduleAfter <= l.DormantAfter || l.Grace < 0 {
+		return nil, fmt.Errorf("lifecycle: expected 0 < DormantAfter < ScheduleAfter and Grace >= 0, got %v, %v, %v",
+			l.DormantAfter, l.ScheduleAfter, l.Grace)
+	}
+
+	now := db.NowFunc()
+	report := &LifecycleReport{Now: now, DryRun: l.DryRun}
+
+	// plan every step before moving anyone, so a user moves by one stage at most
+	steps := l.steps(now)
+	due := make([][]User, len(steps))
+	for i, s := range steps {
+		if err := db.Select("id", "name", "email", "last_login_at").Where("stage = ?", s.from).Scopes(s.cond).
+			Order("id").Find(&due[i]).Error; err != nil {
+			return nil, fmt.Errorf("lifecycle: %s users: %w", s.from, err)
+		}
+	}
+
+	for i, s := range steps {
+		for _, u := range due[i] {
+			move := Move{UserID: u.ID, Name: u.Name, Email: u.Email, From: s.from, To: s.to, LastLoginAt: u.LastLoginAt}
+			if l.DryRun {
+				report.Moves = append(report.Moves, move)
+				continue
+			}
+
+			moved, err := advance(db, u.ID, s, now)
+			if err != nil {
+				return report, fmt.Errorf("lifecycle: user %d: %w", u.ID, err)
+			}
+			if moved {
+				report.Moves = append(report.Moves, move)
+			}
+		}
+	}
+	return report, nil
+}
+
+// advance moves user id by step s, unless it no longer qualifies (it logged in since the plan).
+func advance(db *gorm.DB, id uint, s step, now time.Time) (bool, error) {
+	moved := false
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// the conditions of the plan are checked again by the UPDATE itself
+		result := tx.Model(&User{}).Where("id = ? AND stage = ?", id, s.from).Scopes(s.cond).
+			Updates(map[string]any{"stage": s.to, "stage_changed_at": now})
+		if result.Error != nil {
+			return result.Error // roll back
+		}
+		if result.RowsAffected == 0 {
+			return nil
+		}
+
+		if s.to == StageDeleted {
+			if err := tx.Delete(&User{}, id).Error; err != nil {
+				return err // roll back
+			}
+		}
+		if err := tx.Create(&UserTransition{UserID: id, FromStage: s.from, ToStage: s.to, Reason: s.reason}).Error; err != nil {
+			return err // roll back
+		}
+		moved = true
+		return nil
+	})
+	return moved, err
+}
+
+// RecordLogin stamps a login of user id, a dormant or scheduled user is back to active.
+// A deleted user is not found (gorm.ErrRecordNotFound), only restoring it brings it back.
+func RecordLogin(db *gorm.DB, id uint) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		// write first, so no sweep can move the user between reading its stage and reactivating it
+		now := tx.NowFunc()
+		result := tx.Model(&User{}).Where("id = ?", id).Update("last_login_at", now)
+		if result.Error != nil {
+			return result.Error // roll back
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+
+		var u User
+		if err := tx.Select("id", "stage").First(&u, id).Error; err != nil {
+			return err // roll back
+		}
+		from := u.Stage
+		if from == StageActive {
+			return nil
+		}
+
+		if err := tx.Model(&u).Updates(map[string]any{"stage": StageActive, "stage_changed_at": now}).Error; err != nil {
+			return err // roll back
+		}
+		return tx.Create(&UserTransition{UserID: id, FromStage: from, ToStage: StageActive, Reason: "login"}).Error
+	})
+}
+
+func lifecycleTest(db *gorm.DB) {
+	// the seeded users have only just signed up, pretend it was a year ago
+	if err := db.Model(&User{}).Where("1 = 1").UpdateColumn("created_at", db.NowFunc().AddDate(-1, 0, 0)).Error; err != nil {
+		panic(err)
+	}
+
+	l := Lifecycle{DormantAfter: 7 * 24 * time.Hour, ScheduleAfter: 14 * 24 * time.Hour, DryRun: true}
+	report, err := AdvanceLifecycle(db, l)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("dry run, would move %d users: %+v\n", len(report.Moves), report.Moves)
+
+	// three sweeps: dormant, then scheduled, then deleted once the grace period (none here) is over
+	l.DryRun = false
+	for sweep := 1; sweep <= 3; sweep++ {
+		if report, err = AdvanceLifecycle(db, l); err != nil {
+			panic(err)
+		}
+		fmt.Printf("sweep %d moved %d users: %+v\n", sweep, len(report.Moves), report.Moves)
+
+		// a dormant user comes back
+		if sweep == 1 && len(report.Moves) > 0 {
+			if err := RecordLogin(db, report.Moves[len(report.Moves)-1].UserID); err != nil {
+				panic(err)
+			}
+		}
+	}
+
+	var transitions []UserTransition
+	if err := db.Order("id").Find(&transitions).Error; err != nil {
+		panic(err)
+	}
+	for _, t := range transitions {
+		fmt.Printf("user %d: %s → %s (%s)\n", t.UserID, t.FromStage, t.ToStage, t.Reason)
+	}
+}
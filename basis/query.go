@@ -3,18 +3,23 @@ package basis
 import (
 	"fmt"
 
+	"gorm/filter"
+	"gorm/pagination"
+
 	"gorm.io/gorm"
 )
 
 func QueryTest() {
 	db := setup("db/query.db")
 	scopedTest(db)
+	keysetTest(db)
 	likeTest(db)
 	groupTest(db)
-	if users, err := searchUsersByEmail(db, "%@gmail.com", 1, 2); err != nil {
+	filterTest(db)
+	if page, err := searchUsersByEmail(db, "%@gmail.com", 1, 2); err != nil {
 		panic(err)
 	} else {
-		fmt.Printf("%d users on current page: %v\n", len(users), users)
+		fmt.Printf("page %d of %d, %d of %d users: %v\n", page.Page, page.TotalPages, len(page.Items), page.Total, page.Items)
 	}
 	// More examples:
 	// db.Where("status IN ?", []string{"active", "pending"}).Find(&users)
@@ -84,6 +89,54 @@ func orderByCreated(desc bool) func(db *gorm.DB) *gorm.DB {
 	}
 }
 
+// Keyset pagination, an alternative to paginate for large or fast-growing tables.
+// Instead of OFFSET it seeks past the (created_at, id) of the last row seen,
+// so the cost of a page doesn't grow with its number and concurrent inserts don't shift pages.
+func keysetTest(db *gorm.DB) {
+	cursor := ""
+	for n := 1; ; n++ {
+		page, err := pagination.Paginate[User](db.Scopes(active()), pagination.Keyset{Desc: true, Cursor: cursor, Limit: 2})
+		if err != nil {
+			panic(err)
+		}
+		fmt.Printf("page %d: %v\n", n, page.Items)
+
+		if page.NextCursor == "" {
+			break
+		}
+		cursor = page.NextCursor
+	}
+}
+
+// Filter language, the user-facing counterpart of the scopes above.
+// Only whitelisted columns and operators are accepted, the query is compiled into a scope.
+func filterTest(db *gorm.DB) {
+	users, err := filter.New(db, &User{}, map[string]filter.Op{
+		"status":     filter.Eq | filter.In,
+		"age":        filter.Eq | filter.Range,
+		"email":      filter.Eq | filter.Contains,
+		"created_at": filter.Range,
+	}, "created_at", "age", "name")
+	if err != nil {
+		panic(err)
+	}
+
+	scope, err := users.Scope("status:active age:25..40 email~@gmail.com sort:-created_at")
+	if err != nil {
+		panic(err)
+	}
+	found := []User{}
+	if err := db.Scopes(scope).Find(&found).Error; err != nil {
+		panic(err)
+	}
+	fmt.Println("filtered users:", found)
+
+	// not whitelisted, the error points at the token
+	if _, err := users.Scope("status:active phone:4239085657"); err != nil {
+		fmt.Println(err)
+	}
+}
+
 func youngUsers(min, max, pageNum, pageSize int) func(db *gorm.DB) *gorm.DB {
 	return func(db *gorm.DB) *gorm.DB {
 		return db.Scopes(ageBetween(min, max), paginate(pageNum, pageSize))
@@ -114,12 +167,11 @@ func groupTest(db *gorm.DB) {
 	fmt.Println("group users by status,", sc)
 }
 
-func searchUsersByEmail(db *gorm.DB, emailPattern string, pageNum, pageSize int) ([]User, error) {
-	var users []User
-
-	if err := db.Scopes(paginate(pageNum, pageSize)).Where("email LIKE ?", emailPattern).Find(&users).Error; err != nil {
-		return nil, err
-	}
-
-	return users, nil
+// searchUsersByEmail returns a page of the matching users with the total, unlike the paginate scope
+// it rejects out of range page numbers and sizes with pagination.ErrInvalidPage.
+func searchUsersByEmail(db *gorm.DB, emailPattern string, pageNum, pageSize int) (*pagination.Page[User], error) {
+	return pagination.PaginateOffset[User](
+		db.Where("email LIKE ?", emailPattern).Order("id"),
+		pagination.Offset{Page: pageNum, Size: pageSize},
+	)
 }
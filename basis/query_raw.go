@@ -2,7 +2,6 @@ package basis
 
 import (
 	"fmt"
-	"time"
 
 	"gorm.io/gorm"
 )
@@ -23,8 +22,8 @@ func rawGroupTest(db *gorm.DB) {
 	}
 
 	ss := []StatusSummary{}
-	start := time.Now().AddDate(0, 0, -60)
-	end := time.Now()
+	start := db.NowFunc().AddDate(0, 0, -60)
+	end := db.NowFunc()
 	if err := db.Raw(`
 		SELECT status, COUNT(*) AS total, AVG(age) AS avg_age
 		FROM USERS
@@ -37,7 +36,7 @@ func rawGroupTest(db *gorm.DB) {
 }
 
 func rawUpdateTest(db *gorm.DB) {
-	result := db.Exec("UPDATE users SET status = ? WHERE last_login_at < ?", "inactive", time.Now().AddDate(0, 0, -30))
+	result := db.Exec("UPDATE users SET status = ? WHERE last_login_at < ?", "inactive", db.NowFunc().AddDate(0, 0, -30))
 	if result.Error != nil {
 		panic(result.Error)
 	}
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"time"
 
+	"gorm/dbtime"
+
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
@@ -11,17 +13,18 @@ import (
 
 func setup(dsn string) *gorm.DB {
 	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{
-		Logger: logger.Default.LogMode(logger.Info),
+		Logger:  logger.Default.LogMode(logger.Info),
+		NowFunc: dbtime.Now,
 	})
 	if err != nil {
 		panic(fmt.Sprintf("Failed to open %s, %v\n", dsn, err))
 	}
 
-	if err = db.AutoMigrate(&User{}); err != nil {
+	if err = db.AutoMigrate(&User{}, &UserTransition{}); err != nil {
 		panic(fmt.Sprintf("failed to auto migrate, %v\n", err))
 	}
 
-	now := time.Now()
+	now := db.NowFunc()
 	users := []User{
 		{
 			Name:        "Alice",
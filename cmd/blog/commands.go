@@ -0,0 +1,346 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm/project"
+
+	"gorm.io/gorm"
+)
+
+// tagList collects the values of a repeated --tag flag.
+type tagList []string
+
+func (l *tagList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *tagList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+// resolveTag finds a tag by ID or slug.
+func resolveTag(db *gorm.DB, v string) (*project.Tag, error) {
+	var tag project.Tag
+	query := db.Where("slug = ?", v)
+	if id, err := strconv.ParseUint(v, 10, 64); err == nil {
+		query = db.Where("id = ?", id)
+	}
+	if err := query.First(&tag).Error; err != nil {
+		return nil, fmt.Errorf("tag %q: %w", v, err)
+	}
+	return &tag, nil
+}
+
+func userRows(users []project.User) [][]string {
+	rows := make([][]string, len(users))
+	for i, u := range users {
+		rows[i] = []string{strconv.FormatUint(uint64(u.ID), 10), u.Name, u.Email, strconv.FormatBool(u.Moderator)}
+	}
+	return rows
+}
+
+var userHeader = []string{"ID", "NAME", "EMAIL", "MODERATOR"}
+
+func userCreate(c *cli, args []string) error {
+	fs := c.flags("user create")
+	name := fs.String("name", "", "display name")
+	email := fs.String("email", "", "email, unique")
+	if err := c.parse(fs, args); err != nil {
+		return err
+	}
+	if err := required(map[string]bool{"name": *name != "", "email": *email != ""}); err != nil {
+		return err
+	}
+
+	db, err := c.open()
+	if err != nil {
+		return err
+	}
+	u, err := project.CreateUser(db, *name, *email)
+	if err != nil {
+		return err
+	}
+	return c.print(u, userHeader, userRows([]project.User{*u}))
+}
+
+func userList(c *cli, args []string) error {
+	fs := c.flags("user list")
+	if err := c.parse(fs, args); err != nil {
+		return err
+	}
+
+	db, err := c.open()
+	if err != nil {
+		return err
+	}
+	users, err := project.ListUsers(db)
+	if err != nil {
+		return err
+	}
+	return c.print(users, userHeader, userRows(users))
+}
+
+func userModerator(c *cli, args []string) error {
+	fs := c.flags("user moderator")
+	id := fs.Uint("id", 0, "ID of the user")
+	revoke := fs.Bool("revoke", false, "take the moderator role away instead of granting it")
+	if err := c.parse(fs, args); err != nil {
+		return err
+	}
+	if err := required(map[string]bool{"id": *id != 0}); err != nil {
+		return err
+	}
+
+	db, err := c.open()
+	if err != nil {
+		return err
+	}
+	if err := project.SetModerator(db, *id, !*revoke); err != nil {
+		return err
+	}
+	var u project.User
+	if err := db.First(&u, *id).Error; err != nil {
+		return err
+	}
+	return c.print(u, userHeader, userRows([]project.User{u}))
+}
+
+var postHeader = []string{"ID", "USER", "STATUS", "CREATED", "SUBJECT", "TAGS"}
+
+func postRows(posts []project.Post) [][]string {
+	rows := make([][]string, len(posts))
+	for i, p := range posts {
+		tags := make([]string, len(p.Tags))
+		for j, t := range p.Tags {
+			tags[j] = t.Slug
+		}
+		rows[i] = []string{
+			strconv.FormatUint(uint64(p.ID), 10),
+			strconv.FormatUint(uint64(p.UserID), 10),
+			string(p.Status),
+			p.CreatedAt.Format(time.DateTime),
+			abbrev(p.Subject, 40),
+			strings.Join(tags, ","),
+		}
+	}
+	return rows
+}
+
+func postPublish(c *cli, args []string) error {
+	fs := c.flags("post publish")
+	userID := fs.Uint("user", 0, "ID of the author")
+	subject := fs.String("subject", "", "subject")
+	content := fs.String("content", "", "Markdown content, - reads it from stdin")
+	var tags tagList
+	fs.Var(&tags, "tag", "ID or slug of a tag, repeatable")
+	if err := c.parse(fs, args); err != nil {
+		return err
+	}
+	if err := required(map[string]bool{"user": *userID != 0, "subject": *subject != "", "content": *content != ""}); err != nil {
+		return err
+	}
+
+	text, err := c.text(*content)
+	if err != nil {
+		return err
+	}
+	db, err := c.open()
+	if err != nil {
+		return err
+	}
+	tagIDs := make([]uint, len(tags))
+	for i, v := range tags {
+		tag, err := resolveTag(db, v)
+		if err != nil {
+			return err
+		}
+		tagIDs[i] = tag.ID
+	}
+
+	p, err := project.PublishPostWithTags(db, *userID, *subject, text, tagIDs)
+	if err != nil {
+		return err
+	}
+	return c.print(p, postHeader, postRows([]project.Post{*p}))
+}
+
+func postList(c *cli, args []string) error {
+	fs := c.flags("post list")
+	userID := fs.Uint("user", 0, "only the posts of this user")
+	limit := fs.Int("limit", 10, "number of posts, latest first")
+	if err := c.parse(fs, args); err != nil {
+		return err
+	}
+	if *limit <= 0 {
+		return fmt.Errorf("%w: --limit must be positive", errUsage)
+	}
+
+	db, err := c.open()
+	if err != nil {
+		return err
+	}
+	var posts []project.Post
+	if *userID != 0 {
+		if err := db.First(&project.User{}, *userID).Error; err != nil {
+			return fmt.Errorf("user %d: %w", *userID, err)
+		}
+		posts, err = project.GetUserLatestPosts(db, *userID, *limit)
+	} else {
+		posts, err = project.ListPosts(db, *limit)
+	}
+	if err != nil {
+		return err
+	}
+	return c.print(posts, postHeader, postRows(posts))
+}
+
+func commentAdd(c *cli, args []string) error {
+	fs := c.flags("comment add")
+	postID := fs.Uint("post", 0, "ID of the post")
+	userID := fs.Uint("user", 0, "ID of the commenter")
+	content := fs.String("content", "", "content, - reads it from stdin")
+	if err := c.parse(fs, args); err != nil {
+		return err
+	}
+	if err := required(map[string]bool{"post": *postID != 0, "user": *userID != 0, "content": *content != ""}); err != nil {
+		return err
+	}
+
+	text, err := c.text(*content)
+	if err != nil {
+		return err
+	}
+	db, err := c.open()
+	if err != nil {
+		return err
+	}
+	cm, err := project.AddComment(db, *postID, *userID, text)
+	if err != nil {
+		return err
+	}
+	// the moderation policy may hold the comment for review
+	return c.print(cm, []string{"ID", "POST", "USER", "STATUS", "CONTENT"}, [][]string{{
+		strconv.FormatUint(uint64(cm.ID), 10),
+		strconv.FormatUint(uint64(cm.PostID), 10),
+		strconv.FormatUint(uint64(cm.UserID), 10),
+		string(cm.Status),
+		abbrev(cm.Content, 40),
+	}})
+}
+
+func commentDelete(c *cli, args []string) error {
+	fs := c.flags("comment delete")
+	id := fs.Uint("id", 0, "ID of the comment")
+	hard := fs.Bool("hard", false, "delete the rows of the comment and its replies instead of soft deleting it")
+	if err := c.parse(fs, args); err != nil {
+		return err
+	}
+	if err := required(map[string]bool{"id": *id != 0}); err != nil {
+		return err
+	}
+
+	db, err := c.open()
+	if err != nil {
+		return err
+	}
+	if *hard {
+		err = project.HardDeleteComment(db, *id)
+	} else {
+		err = project.SoftDeleteComment(db, *id)
+	}
+	if err != nil {
+		return err
+	}
+	return c.print(struct {
+		ID   uint
+		Hard bool
+	}{*id, *hard}, []string{"DELETED", "HARD"}, [][]string{{strconv.FormatUint(uint64(*id), 10), strconv.FormatBool(*hard)}})
+}
+
+func tagMerge(c *cli, args []string) error {
+	fs := c.flags("tag merge")
+	from := fs.String("from", "", "ID or slug of the tag to merge, deleted afterwards")
+	into := fs.String("into", "", "ID or slug of the tag to merge into")
+	if err := c.parse(fs, args); err != nil {
+		return err
+	}
+	if err := required(map[string]bool{"from": *from != "", "into": *into != ""}); err != nil {
+		return err
+	}
+
+	db, err := c.open()
+	if err != nil {
+		return err
+	}
+	source, err := resolveTag(db, *from)
+	if err != nil {
+		return err
+	}
+	target, err := resolveTag(db, *into)
+	if err != nil {
+		return err
+	}
+
+	moved, err := project.MergeTags(db, source.ID, target.ID)
+	if err != nil {
+		return err
+	}
+	return c.print(struct {
+		From  string
+		Into  string
+		Moved int64
+	}{source.Slug, target.Slug, moved}, []string{"FROM", "INTO", "MOVED"}, [][]string{{
+		source.Slug, target.Slug, strconv.FormatInt(moved, 10),
+	}})
+}
+
+func seed(c *cli, args []string) error {
+	opts := project.DefaultSeedOptions
+	fs := c.flags("seed")
+	fs.Int64Var(&opts.Seed, "seed", opts.Seed, "seed of the random generator, the same seed gives the same data")
+	if err := c.parse(fs, args); err != nil {
+		return err
+	}
+
+	db, err := c.open()
+	if err != nil {
+		return err
+	}
+	res, err := project.Seed(db, opts)
+	if err != nil {
+		return err
+	}
+	return c.print(res, []string{"USERS", "TAGS", "POSTS", "COMMENTS"}, [][]string{{
+		strconv.Itoa(len(res.UserIDs)),
+		strconv.Itoa(len(res.TagIDs)),
+		strconv.Itoa(len(res.PostIDs)),
+		strconv.Itoa(len(res.CommentIDs)),
+	}})
+}
+
+func renderPosts(c *cli, args []string) error {
+	fs := c.flags("render-posts")
+	all := fs.Bool("all", false, "re-render every post, not only those without HTML (after a change of the renderer)")
+	if err := c.parse(fs, args); err != nil {
+		return err
+	}
+
+	// open migrates, which adds the content_html column to databases created before it existed
+	db, err := c.open()
+	if err != nil {
+		return err
+	}
+	n, err := project.RenderPostsHTML(db, *all)
+	if err != nil {
+		return err
+	}
+	return c.print(struct {
+		Rendered int
+		All      bool
+	}{n, *all}, []string{"RENDERED", "ALL"}, [][]string{{strconv.Itoa(n), strconv.FormatBool(*all)}})
+}
@@ -0,0 +1,216 @@
+// blog manages the blog database from the command line.
+//
+//	blog user create --name NAME --email EMAIL
+//	blog user list
+//	blog user moderator --id ID [--revoke]
+//	blog post publish --user ID --subject TEXT --content TEXT|- [--tag ID|SLUG]...
+//	blog post list [--user ID] [--limit N]
+//	blog comment add --post ID --user ID --content TEXT|-
+//	blog comment delete --id ID [--hard]
+//	blog tag merge --from ID|SLUG --into ID|SLUG
+//	blog seed [--seed N]
+//	blog render-posts [--all]
+//	blog serve [--addr HOST:PORT] [--trust-user-header] [--keep-views DURATION]
+//
+// serve runs the HTTP API until SIGINT or SIGTERM, along with the loops publishing scheduled posts,
+// rolling up old post views and delivering notifications. Without --trust-user-header every request is anonymous.
+// render-posts renders the cached HTML of the posts without one, --all re-renders every post (after a change
+// of the renderer, or writes that skipped the hooks), see project/markdown.go.
+//
+// Every command also takes --db PATH (db/blog.db by default, migrated on open) and --output table|json.
+// Results go to stdout and errors to stderr, so the JSON output can be piped:
+//
+//	blog post list --user 1 --output json | jq '.[].ID'
+//
+// The exit status is 0 on success, 1 when the command failed and 2 on usage errors.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"gorm/dbtime"
+	"gorm/project"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+var errUsage = errors.New("usage")
+
+// cli holds the options shared by every command.
+type cli struct {
+	dbPath string
+	output string
+	stdin  io.Reader
+	stdout io.Writer
+}
+
+type command struct {
+	usage string
+	run   func(c *cli, args []string) error
+}
+
+var commands = map[string]command{
+	"user create":    {"--name NAME --email EMAIL", userCreate},
+	"user list":      {"", userList},
+	"user moderator": {"--id ID [--revoke]", userModerator},
+	"post publish":   {"--user ID --subject TEXT --content TEXT|- [--tag ID|SLUG]...", postPublish},
+	"post list":      {"[--user ID] [--limit N]", postList},
+	"comment add":    {"--post ID --user ID --content TEXT|-", commentAdd},
+	"comment delete": {"--id ID [--hard]", commentDelete},
+	"tag merge":      {"--from ID|SLUG --into ID|SLUG", tagMerge},
+	"seed":           {"[--seed N]", seed},
+	"render-posts":   {"[--all]", renderPosts},
+	"serve":          {"[--addr HOST:PORT] [--trust-user-header] [--keep-views DURATION]", serve},
+}
+
+func usage(w io.Writer) {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(w, "usage: blog <command> [flags] [--db PATH] [--output table|json]")
+	fmt.Fprintln(w, "commands:")
+	for _, name := range names {
+		fmt.Fprintln(w, "  "+strings.TrimSpace(name+" "+commands[name].usage))
+	}
+}
+
+func main() {
+	c := &cli{stdin: os.Stdin, stdout: os.Stdout}
+	err := c.dispatch(os.Args[1:])
+	switch {
+	case err == nil, errors.Is(err, flag.ErrHelp):
+	case errors.Is(err, errUsage):
+		fmt.Fprintln(os.Stderr, "blog:", err)
+		os.Exit(2)
+	default:
+		fmt.Fprintln(os.Stderr, "blog:", err)
+		os.Exit(1)
+	}
+}
+
+// dispatch finds the command named by the first one or two arguments and runs it with the rest.
+func (c *cli) dispatch(args []string) error {
+	for n := 2; n >= 1; n-- {
+		if len(args) < n {
+			continue
+		}
+		name := strings.Join(args[:n], " ")
+		if cmd, ok := commands[name]; ok {
+			return cmd.run(c, args[n:])
+		}
+	}
+
+	usage(os.Stderr)
+	if len(args) == 0 {
+		return fmt.Errorf("%w: missing command", errUsage)
+	}
+	return fmt.Errorf("%w: unknown command %q", errUsage, strings.Join(args, " "))
+}
+
+// flags returns the flag set of a command, with the shared --db and --output flags.
+func (c *cli) flags(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet("blog "+name, flag.ContinueOnError)
+	fs.StringVar(&c.dbPath, "db", "db/blog.db", "SQLite database of the blog")
+	fs.StringVar(&c.output, "output", "table", "output format, table or json")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: blog %s [flags]\n", name)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parse parses the flags of a command, the flag set has already reported parse errors.
+func (c *cli) parse(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+		return fmt.Errorf("%w: %v", errUsage, err)
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("%w: unexpected argument %q", errUsage, fs.Arg(0))
+	}
+	if c.output != "table" && c.output != "json" {
+		return fmt.Errorf("%w: --output must be table or json", errUsage)
+	}
+	return nil
+}
+
+// required reports the first flag left at its zero value.
+func required(flags map[string]bool) error {
+	names := make([]string, 0, len(flags))
+	for name, set := range flags {
+		if !set {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	sort.Strings(names)
+	return fmt.Errorf("%w: --%s is required", errUsage, names[0])
+}
+
+func (c *cli) open() (*gorm.DB, error) {
+	db, err := gorm.Open(sqlite.Open(c.dbPath), &gorm.Config{
+		// stdout is for the results, and failed queries are reported as the error of the command
+		Logger:  logger.Discard,
+		NowFunc: dbtime.Now,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := project.Migrate(db); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// text reads "-" from stdin, any other value is the text itself.
+func (c *cli) text(v string) (string, error) {
+	if v != "-" {
+		return v, nil
+	}
+	b, err := io.ReadAll(c.stdin)
+	return string(b), err
+}
+
+// print writes v as JSON, or header and rows as an aligned table.
+func (c *cli) print(v any, header []string, rows [][]string) error {
+	if c.output == "json" {
+		enc := json.NewEncoder(c.stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+
+	tw := tabwriter.NewWriter(c.stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	return tw.Flush()
+}
+
+// abbrev shortens s to its first line and at most n runes, for table cells.
+func abbrev(s string, n int) string {
+	s, _, cut := strings.Cut(s, "\n")
+	if r := []rune(s); len(r) > n {
+		return string(r[:n-1]) + "…"
+	} else if cut {
+		return s + "…"
+	}
+	return s
+}
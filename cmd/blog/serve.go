@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"gorm/project"
+
+	"gorm.io/gorm/logger"
+)
+
+// serve runs the API and owns the background loops of the blog: the scheduler publishing scheduled posts,
+// the rollup of old post views and the dispatcher of the notification outbox. They all stop on SIGINT or SIGTERM.
+func serve(c *cli, args []string) error {
+	fs := c.flags("serve")
+	addr := fs.String("addr", "localhost:8080", "address to listen on")
+	trustHeader := fs.Bool("trust-user-header", false, "identify callers by the X-User-ID header, only behind an authenticating proxy")
+	keepViews := fs.Duration("keep-views", 7*24*time.Hour, "age at which raw post views are rolled up")
+	if err := c.parse(fs, args); err != nil {
+		return err
+	}
+
+	db, err := c.open()
+	if err != nil {
+		return err
+	}
+	// the loops have no caller to return their errors to
+	errLog := log.New(os.Stderr, "blog: ", log.LstdFlags)
+	db.Logger = logger.New(errLog, logger.Config{LogLevel: logger.Error, IgnoreRecordNotFoundError: true})
+
+	api := project.NewAPI(db)
+	if *trustHeader {
+		api.Authenticate = project.HeaderAuth
+	}
+	srv := &http.Server{Addr: *addr, Handler: api, ReadHeaderTimeout: 10 * time.Second}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// a log line per notification until a real notifier (mail, push) is plugged in
+	notifier := project.NotifierFunc(func(ctx context.Context, n project.Notification) error {
+		errLog.Printf("notify user %d: %s on post %d", n.RecipientID, n.Kind, n.PostID)
+		return nil
+	})
+	loops := []func(context.Context){
+		project.NewScheduler(db, time.Minute).Run,
+		project.NewViewRollup(db, time.Hour, *keepViews).Run,
+		project.NewDispatcher(db, notifier, 5*time.Second).Run,
+	}
+	var wg sync.WaitGroup
+	for _, run := range loops {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			run(ctx)
+		}()
+	}
+
+	shutdownErr := make(chan error, 1)
+	go func() {
+		<-ctx.Done()
+		// let the requests in flight finish
+		shutdown, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		shutdownErr <- srv.Shutdown(shutdown)
+	}()
+
+	fmt.Fprintf(os.Stderr, "blog: listening on %s\n", *addr)
+	err = srv.ListenAndServe()
+	stop()
+	if errors.Is(err, http.ErrServerClosed) {
+		err = <-shutdownErr
+	}
+	wg.Wait()
+	return err
+}
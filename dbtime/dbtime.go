@@ -0,0 +1,15 @@
+// Package dbtime keeps the timestamps of the SQLite databases in UTC.
+//
+// SQLite has no timestamp type: the driver writes a time.Time as text in the zone of the value,
+// "2024-01-01 12:00:00+02:00", and WHERE clauses compare that text. Two zones in the same column make
+// "<" wrong, so the databases comparing timestamps (the user lifecycle, the blog, its retention and tools)
+// are opened with NowFunc: dbtime.Now, and times coming from outside (API bodies, query parameters, flags)
+// are converted with UTC before being written or compared.
+package dbtime
+
+import "time"
+
+// Now is the NowFunc of those databases, it fills CreatedAt, UpdatedAt and DeletedAt in UTC.
+func Now() time.Time {
+	return time.Now().UTC()
+}
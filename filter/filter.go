@@ -0,0 +1,467 @@
+// Filter queries
+//
+// Search boxes and query strings describe a filter as text:
+//
+// status:active age:25..40 email~@gmail.com sort:-created_at
+//
+// A Filter compiles such a query into a scope, after checking every term against a whitelist of the model:
+//
+// | Term              | Operator | SQL                                 |
+// | ----------------- | -------- | ----------------------------------- |
+// | status:active     | Eq       | status = 'active'                   |
+// | status:active,new | In       | status IN ('active','new')          |
+// | age:25..40        | Range    | age >= 25 AND age <= 40             |
+// | age:25..          | Range    | age >= 25                           |
+// | age:>25, age:<=40 | Range    | age > 25, age <= 40                 |
+// | email~@gmail.com  | Contains | email LIKE '%@gmail.com%' ESCAPE \  |
+// | -status:active    | (any)    | NOT status = 'active'               |
+// | sort:-age,name    |          | ORDER BY age DESC, name, id         |
+//
+// Terms are separated by spaces and all of them must match. A value in double quotes is taken literally,
+// with its spaces, commas and dots: name:"Smith, John". Values are parsed as the type of the column
+// (numbers, booleans, times as RFC 3339 or 2006-01-02, a date standing for the whole UTC day) and always
+// passed as bind variables, column names only ever come from the model, so a query can't inject SQL.
+//
+// The sort term takes a spec of the sorting package: NULLS FIRST/LAST, association columns and
+// a tiebreak on the primary key.
+//
+// Errors are *SyntaxError, with the position of the offending token in the query.
+
+package filter
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm/sorting"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+var ErrInvalidFilter = errors.New("invalid filter")
+
+// Op is a set of operators allowed on a field.
+type Op uint
+
+const (
+	Eq       Op = 1 << iota // field:value
+	In                      // field:a,b,c
+	Range                   // field:lo..hi, field:>v, field:>=v, field:<v, field:<=v
+	Contains                // field~text, strings only
+)
+
+// SortKey is the reserved field name of the sort term.
+const SortKey = "sort"
+
+// SyntaxError reports the token of a query that can't be parsed or isn't allowed, it matches ErrInvalidFilter.
+type SyntaxError struct {
+	Pos   int    // byte offset of Token in the query
+	Token string // offending part of the query
+	Msg   string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("filter: %s at position %d: %q", e.Msg, e.Pos, e.Token)
+}
+
+func (e *SyntaxError) Unwrap() error {
+	return ErrInvalidFilter
+}
+
+// Filter is the whitelist of the columns of a model that queries may filter and sort on.
+type Filter struct {
+	schema *schema.Schema
+	fields map[string]Op
+	sorter *sorting.Sorter // nil when nothing is sortable
+}
+
+// New checks the whitelist against model: fields maps column names to the operators allowed on them,
+// sortable lists the keys sort may order by, columns of the model or of its associations, see sorting.New.
+//
+// f, err := filter.New(db, &User{}, map[string]filter.Op{"status": filter.Eq | filter.In}, "created_at")
+func New(db *gorm.DB, model any, fields map[string]Op, sortable ...string) (*Filter, error) {
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(model); err != nil {
+		return nil, err
+	}
+
+	f := &Filter{schema: stmt.Schema, fields: make(map[string]Op)}
+	for name, ops := range fields {
+		field := stmt.Schema.LookUpField(name)
+		if field == nil || field.DBName != name {
+			return nil, fmt.Errorf("filter: %s has no column %q", stmt.Schema.Name, name)
+		}
+		if ops&Contains != 0 && field.DataType != schema.String {
+			return nil, fmt.Errorf("filter: Contains on %s.%s, which isn't a string", stmt.Schema.Name, name)
+		}
+		f.fields[name] = ops
+	}
+	if len(sortable) > 0 {
+		sorter, err := sorting.New(db, model, sortable...)
+		if err != nil {
+			return nil, err
+		}
+		f.sorter = sorter
+	}
+	return f, nil
+}
+
+// Scope compiles query into a scope, an empty query matches everything.
+func (f *Filter) Scope(query string) (func(*gorm.DB) *gorm.DB, error) {
+	tokens, err := tokenize(query)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		conds  []clause.Expression
+		order  clause.OrderBy
+		sorted bool
+	)
+	for _, t := range tokens {
+		term, err := splitTerm(t)
+		if err != nil {
+			return nil, err
+		}
+
+		if term.field.text == SortKey {
+			if sorted {
+				return nil, term.token.errorf("duplicate sort")
+			}
+			sorted = true
+			if order, err = f.compileSort(term); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		cond, err := f.compileTerm(term)
+		if err != nil {
+			return nil, err
+		}
+		conds = append(conds, cond)
+	}
+
+	return func(db *gorm.DB) *gorm.DB {
+		if len(conds) > 0 {
+			db = db.Where(clause.And(conds...))
+		}
+		if sorted {
+			db = db.Order(order)
+		}
+		return db
+	}, nil
+}
+
+// ===== lexing =====
+
+type token struct {
+	text string
+	pos  int
+}
+
+func (t token) errorf(format string, args ...any) error {
+	return &SyntaxError{Pos: t.pos, Token: t.text, Msg: fmt.Sprintf(format, args...)}
+}
+
+// sub returns the part of t from byte i to j.
+func (t token) sub(i, j int) token {
+	return token{text: t.text[i:j], pos: t.pos + i}
+}
+
+// tokenize splits the query on spaces outside of double quotes.
+func tokenize(query string) ([]token, error) {
+	var tokens []token
+	start, quote := -1, -1
+	for i, r := range query {
+		switch {
+		case r == '"':
+			if start < 0 {
+				start = i
+			}
+			if quote < 0 {
+				quote = i
+			} else {
+				quote = -1
+			}
+		case quote < 0 && (r == ' ' || r == '\t' || r == '\n'):
+			if start >= 0 {
+				tokens = append(tokens, token{text: query[start:i], pos: start})
+				start = -1
+			}
+		case start < 0:
+			start = i
+		}
+	}
+	if quote >= 0 {
+		return nil, &SyntaxError{Pos: quote, Token: query[quote:], Msg: "unterminated quote"}
+	}
+	if start >= 0 {
+		tokens = append(tokens, token{text: query[start:], pos: start})
+	}
+	return tokens, nil
+}
+
+// term is a token split into [-]field, operator (":" or "~") and value.
+type term struct {
+	token  token
+	not    bool
+	field  token
+	op     byte
+	value  token
+	quoted bool // the value was in double quotes, it is taken literally
+}
+
+func isNameByte(c byte) bool {
+	return c == '_' || '0' <= c && c <= '9' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+func splitTerm(t token) (term, error) {
+	tm := term{token: t}
+	i := 0
+	if strings.HasPrefix(t.text, "-") {
+		tm.not = true
+		i = 1
+	}
+	j := i
+	for j < len(t.text) && isNameByte(t.text[j]) {
+		j++
+	}
+	if j == i {
+		return tm, t.errorf("expected a field name")
+	}
+	tm.field = t.sub(i, j)
+	if j == len(t.text) || (t.text[j] != ':' && t.text[j] != '~') {
+		return tm, t.errorf(`expected ":" or "~" after %q`, tm.field.text)
+	}
+	tm.op = t.text[j]
+
+	tm.value = t.sub(j+1, len(t.text))
+	if v := tm.value.text; strings.HasPrefix(v, `"`) {
+		if len(v) < 2 || !strings.HasSuffix(v, `"`) {
+			return tm, tm.value.errorf("a quoted value must be the whole value")
+		}
+		tm.value, tm.quoted = tm.value.sub(1, len(v)-1), true
+	}
+	if tm.value.text == "" && !tm.quoted {
+		return tm, t.errorf("missing value")
+	}
+	return tm, nil
+}
+
+// ===== compiling =====
+
+func (f *Filter) compileSort(tm term) (clause.OrderBy, error) {
+	if tm.not || tm.op != ':' || tm.quoted {
+		return clause.OrderBy{}, tm.token.errorf("sort takes sort:field,-field")
+	}
+	if f.sorter == nil {
+		return clause.OrderBy{}, tm.token.errorf("sorting isn't allowed")
+	}
+
+	order, err := f.sorter.OrderBy(tm.value.text)
+	var serr *sorting.SyntaxError
+	if errors.As(err, &serr) {
+		// positions in the query rather than in the spec
+		return order, &SyntaxError{Pos: tm.value.pos + serr.Pos, Token: serr.Token, Msg: serr.Msg}
+	}
+	return order, err
+}
+
+func (f *Filter) compileTerm(tm term) (clause.Expression, error) {
+	name := tm.field.text
+	ops, ok := f.fields[name]
+	if !ok {
+		return nil, tm.field.errorf("unknown field %q", name)
+	}
+	field := f.schema.LookUpField(name)
+	col := clause.Column{Table: clause.CurrentTable, Name: name}
+
+	var (
+		cond clause.Expression
+		err  error
+	)
+	v := tm.value
+	switch {
+	case tm.op == '~':
+		if ops&Contains == 0 {
+			return nil, tm.token.errorf("%q doesn't support ~", name)
+		}
+		cond = clause.Expr{SQL: `? LIKE ? ESCAPE '\'`, Vars: []any{col, "%" + escapeLike(v.text) + "%"}}
+
+	case tm.quoted:
+		cond, err = f.compileEq(tm, ops, field, col)
+
+	case strings.Contains(v.text, ".."):
+		if ops&Range == 0 {
+			return nil, tm.token.errorf("%q doesn't support ranges", name)
+		}
+		lo, hi, _ := strings.Cut(v.text, "..")
+		if lo == "" && hi == "" {
+			return nil, v.errorf("a range needs at least one bound")
+		}
+		var bounds []clause.Expression
+		if lo != "" {
+			bound, err := compare(field, col, ">=", v.sub(0, len(lo)))
+			if err != nil {
+				return nil, err
+			}
+			bounds = append(bounds, bound)
+		}
+		if hi != "" {
+			bound, err := compare(field, col, "<=", v.sub(len(lo)+2, len(v.text)))
+			if err != nil {
+				return nil, err
+			}
+			bounds = append(bounds, bound)
+		}
+		cond = clause.And(bounds...)
+
+	case strings.HasPrefix(v.text, ">") || strings.HasPrefix(v.text, "<"):
+		if ops&Range == 0 {
+			return nil, tm.token.errorf("%q doesn't support comparisons", name)
+		}
+		n := 1
+		if strings.HasPrefix(v.text[1:], "=") {
+			n = 2
+		}
+		cond, err = compare(field, col, v.text[:n], v.sub(n, len(v.text)))
+
+	case strings.Contains(v.text, ","):
+		if ops&In == 0 {
+			return nil, tm.token.errorf("%q doesn't support lists", name)
+		}
+		var values []any
+		for i := 0; i <= len(v.text); {
+			end := strings.IndexByte(v.text[i:], ',')
+			if end < 0 {
+				end = len(v.text)
+			} else {
+				end += i
+			}
+			value, err := parseValue(field, v.sub(i, end))
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, value)
+			i = end + 1
+		}
+		cond = clause.IN{Column: col, Values: values}
+
+	default:
+		cond, err = f.compileEq(tm, ops, field, col)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if tm.not {
+		cond = clause.Not(cond)
+	}
+	return cond, nil
+}
+
+func (f *Filter) compileEq(tm term, ops Op, field *schema.Field, col clause.Column) (clause.Expression, error) {
+	if ops&Eq == 0 {
+		return nil, tm.token.errorf("%q doesn't support :", tm.field.text)
+	}
+	if tm.quoted && field.DataType == schema.String {
+		return clause.Eq{Column: col, Value: tm.value.text}, nil // "" included
+	}
+	value, err := parseValue(field, tm.value)
+	if err != nil {
+		return nil, err
+	}
+	if day, ok := value.(time.Time); ok && isDate(tm.value.text) {
+		return clause.And(clause.Gte{Column: col, Value: day}, clause.Lt{Column: col, Value: day.AddDate(0, 0, 1)}), nil
+	}
+	return clause.Eq{Column: col, Value: value}, nil
+}
+
+// compare builds col op value, op is one of >, >=, < and <=.
+// A date stands for the whole day: created_at:<=2024-12-31 includes the 31st.
+func compare(field *schema.Field, col clause.Column, op string, v token) (clause.Expression, error) {
+	value, err := parseValue(field, v)
+	if err != nil {
+		return nil, err
+	}
+	if day, ok := value.(time.Time); ok && isDate(v.text) {
+		switch op {
+		case ">":
+			return clause.Gte{Column: col, Value: day.AddDate(0, 0, 1)}, nil
+		case "<=":
+			return clause.Lt{Column: col, Value: day.AddDate(0, 0, 1)}, nil
+		}
+	}
+
+	switch op {
+	case ">":
+		return clause.Gt{Column: col, Value: value}, nil
+	case ">=":
+		return clause.Gte{Column: col, Value: value}, nil
+	case "<":
+		return clause.Lt{Column: col, Value: value}, nil
+	default:
+		return clause.Lte{Column: col, Value: value}, nil
+	}
+}
+
+func isDate(s string) bool {
+	_, err := time.Parse(time.DateOnly, s)
+	return err == nil
+}
+
+// parseValue converts the text of a value to the type of the column.
+func parseValue(field *schema.Field, v token) (any, error) {
+	if v.text == "" {
+		return nil, v.errorf("missing value")
+	}
+
+	switch field.DataType {
+	case schema.Bool:
+		b, err := strconv.ParseBool(v.text)
+		if err != nil {
+			return nil, v.errorf("%s expects true or false", field.DBName)
+		}
+		return b, nil
+	case schema.Int:
+		n, err := strconv.ParseInt(v.text, 10, 64)
+		if err != nil {
+			return nil, v.errorf("%s expects an integer", field.DBName)
+		}
+		return n, nil
+	case schema.Uint:
+		n, err := strconv.ParseUint(v.text, 10, 64)
+		if err != nil {
+			return nil, v.errorf("%s expects a non-negative integer", field.DBName)
+		}
+		return n, nil
+	case schema.Float:
+		x, err := strconv.ParseFloat(v.text, 64)
+		if err != nil {
+			return nil, v.errorf("%s expects a number", field.DBName)
+		}
+		return x, nil
+	case schema.Time:
+		if t, err := time.Parse(time.RFC3339, v.text); err == nil {
+			return t.UTC(), nil
+		}
+		t, err := time.Parse(time.DateOnly, v.text)
+		if err != nil {
+			return nil, v.errorf("%s expects a time, 2006-01-02 or RFC 3339", field.DBName)
+		}
+		return t, nil
+	default:
+		return v.text, nil
+	}
+}
+
+// escapeLike escapes the LIKE wildcards of s, for ESCAPE '\'.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
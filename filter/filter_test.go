@@ -0,0 +1,143 @@
+package filter
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+type account struct {
+	ID        uint
+	Name      string
+	Status    string
+	Age       int
+	Score     float64
+	Verified  bool
+	Password  string
+	CreatedAt time.Time
+}
+
+var accountFields = map[string]Op{
+	"name":       Eq | Contains,
+	"status":     Eq | In,
+	"age":        Eq | In | Range,
+	"score":      Range,
+	"verified":   Eq,
+	"created_at": Eq | Range,
+}
+
+func newTestFilter(t *testing.T) (*gorm.DB, *Filter) {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := New(db, &account{}, accountFields, "name", "age", "created_at")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db, f
+}
+
+func TestScopeSQL(t *testing.T) {
+	db, f := newTestFilter(t)
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"", "SELECT * FROM `accounts`"},
+		{"status:active", "SELECT * FROM `accounts` WHERE `accounts`.`status` = \"active\""},
+		{"status:active,new age:25..40",
+			"SELECT * FROM `accounts` WHERE `accounts`.`status` IN (\"active\",\"new\") AND (`accounts`.`age` >= 25 AND `accounts`.`age` <= 40)"},
+		{"age:25..", "SELECT * FROM `accounts` WHERE `accounts`.`age` >= 25"},
+		{"age:>25 score:<=1.5", "SELECT * FROM `accounts` WHERE `accounts`.`age` > 25 AND `accounts`.`score` <= 1.5"},
+		{`name~50%_off -verified:true`,
+			"SELECT * FROM `accounts` WHERE `accounts`.`name` LIKE \"%50\\%\\_off%\" ESCAPE '\\' AND `accounts`.`verified` <> true"},
+		{`name:"Smith, John"`, "SELECT * FROM `accounts` WHERE `accounts`.`name` = \"Smith, John\""},
+		{"created_at:2024-12-31",
+			"SELECT * FROM `accounts` WHERE `accounts`.`created_at` >= \"2024-12-31 00:00:00\" AND `accounts`.`created_at` < \"2025-01-01 00:00:00\""},
+		{"created_at:<=2024-12-31", "SELECT * FROM `accounts` WHERE `accounts`.`created_at` < \"2025-01-01 00:00:00\""},
+		{"sort:-age,name", "SELECT * FROM `accounts` ORDER BY `accounts`.`age` DESC, `accounts`.`name`, `accounts`.`id`"},
+		{"age:25.. sort:created_at",
+			"SELECT * FROM `accounts` WHERE `accounts`.`age` >= 25 ORDER BY `accounts`.`created_at`, `accounts`.`id`"},
+	}
+	for _, tt := range tests {
+		scope, err := f.Scope(tt.query)
+		if err != nil {
+			t.Errorf("%q: %v", tt.query, err)
+			continue
+		}
+		got := db.ToSQL(func(tx *gorm.DB) *gorm.DB { return tx.Scopes(scope).Find(&[]account{}) })
+		if got != tt.want {
+			t.Errorf("%q:\n got %s\nwant %s", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestScopeErrors(t *testing.T) {
+	_, f := newTestFilter(t)
+	tests := []struct {
+		query string
+		pos   int
+		token string
+		msg   string
+	}{
+		// syntax
+		{`name:"open`, 5, `"open`, "unterminated quote"},
+		{"status:active :x", 14, ":x", "expected a field name"},
+		{"status", 0, "status", `expected ":" or "~" after "status"`},
+		{"age:", 0, "age:", "missing value"},
+		{`name:"a"b`, 5, `"a"b`, "a quoted value must be the whole value"},
+		{"age:..", 4, "..", "a range needs at least one bound"},
+		// unknown fields
+		{"status:active bogus:1", 14, "bogus", `unknown field "bogus"`},
+		{"-password:x", 1, "password", `unknown field "password"`},
+		// operators the field doesn't allow
+		{"score:1", 0, "score:1", `"score" doesn't support :`},
+		{"status:a..b", 0, "status:a..b", `"status" doesn't support ranges`},
+		{"status:>a", 0, "status:>a", `"status" doesn't support comparisons`},
+		{"verified:true,false", 0, "verified:true,false", `"verified" doesn't support lists`},
+		{"status~act", 0, "status~act", `"status" doesn't support ~`},
+		// values of the wrong type
+		{"age:abc", 4, "abc", "age expects an integer"},
+		{"age:1,x", 6, "x", "age expects an integer"},
+		{"score:>=x", 8, "x", "score expects a number"},
+		{"verified:maybe", 9, "maybe", "verified expects true or false"},
+		{"created_at:yesterday", 11, "yesterday", "created_at expects a time, 2006-01-02 or RFC 3339"},
+		// sort
+		{"sort:-password", 5, "-password", `can't sort on "password"`},
+		{"sort:age sort:name", 9, "sort:name", "duplicate sort"},
+	}
+	for _, tt := range tests {
+		_, err := f.Scope(tt.query)
+		if !errors.Is(err, ErrInvalidFilter) {
+			t.Errorf("%q: got %v, want ErrInvalidFilter", tt.query, err)
+			continue
+		}
+		var serr *SyntaxError
+		if !errors.As(err, &serr) {
+			t.Errorf("%q: got %T, want *SyntaxError", tt.query, err)
+			continue
+		}
+		if serr.Pos != tt.pos || serr.Token != tt.token || serr.Msg != tt.msg {
+			t.Errorf("%q: got %q at %d (%q), want %q at %d (%q)", tt.query, serr.Msg, serr.Pos, serr.Token, tt.msg, tt.pos, tt.token)
+		}
+	}
+}
+
+func TestNewRejectsInvalidWhitelist(t *testing.T) {
+	db, _ := newTestFilter(t)
+	for name, fields := range map[string]map[string]Op{
+		"unknown column":         {"nickname": Eq},
+		"field name, not column": {"CreatedAt": Range},
+		"Contains on a number":   {"age": Contains},
+	} {
+		if _, err := New(db, &account{}, fields); err == nil {
+			t.Errorf("%s: got no error", name)
+		}
+	}
+}
@@ -2,12 +2,15 @@ module gorm
 
 go 1.25.6
 
+require (
+	golang.org/x/text v0.33.0
+	gorm.io/driver/sqlite v1.6.0
+	gorm.io/gorm v1.31.1
+	gorm.io/plugin/optimisticlock v1.1.3
+)
+
 require (
 	github.com/jinzhu/inflection v1.0.0 // indirect
 	github.com/jinzhu/now v1.1.5 // indirect
 	github.com/mattn/go-sqlite3 v1.14.33 // indirect
-	golang.org/x/text v0.33.0 // indirect
-	gorm.io/driver/sqlite v1.6.0 // indirect
-	gorm.io/gorm v1.31.1 // indirect
-	gorm.io/plugin/optimisticlock v1.1.3
 )
@@ -4,6 +4,8 @@ import (
 	"gorm/advanced"
 	"gorm/basis"
 	"gorm/project"
+	"gorm/repository"
+	"gorm/retention"
 )
 
 func main() {
@@ -21,6 +23,10 @@ func main() {
 	advanced.JoinTest()
 
 	project.BlogTest()
+
+	repository.RepositoryTest()
+
+	retention.RetentionTest()
 }
 
 // import (
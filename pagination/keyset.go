@@ -0,0 +1,203 @@
+// Keyset (cursor) pagination
+//
+// OFFSET pagination asks the database to walk and throw away every skipped row:
+//
+// SELECT * FROM posts ORDER BY created_at DESC LIMIT 10 OFFSET 10000; -- reads 10010 rows
+//
+// It also drifts when rows are inserted between two requests: a new row pushes the
+// previous page down by one, so the last row of page 1 shows up again on page 2.
+//
+// Keyset pagination remembers the sort key of the last row instead and seeks past it:
+//
+// SELECT * FROM posts
+// WHERE (created_at, id) < ('2024-01-01 10:00:00', 42)
+// ORDER BY created_at DESC, id DESC
+// LIMIT 10;
+//
+// With an index on the sort key the database jumps straight to the right position,
+// and concurrent inserts can no longer shift the window.
+// The primary key is the tiebreaker, so rows sharing a timestamp are never skipped.
+
+package pagination
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Keyset describes a page request ordered by (Column, primary key).
+type Keyset struct {
+	Column string // timestamp column (time.Time or non-NULL *time.Time), defaults to "created_at"
+	Desc   bool   // newest first
+	Cursor string // NextCursor or PrevCursor of a previous page, empty for the first page
+	Limit  int
+}
+
+// KeysetPage is one page of a keyset query. Cursors are opaque to the caller
+// and empty when there is nothing more in that direction.
+type KeysetPage[T any] struct {
+	Items      []T
+	NextCursor string
+	PrevCursor string
+}
+
+type cursor struct {
+	Time     time.Time `json:"t"`
+	ID       uint64    `json:"id"`
+	Backward bool      `json:"b,omitempty"`
+}
+
+func (c cursor) encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(s string) (cursor, error) {
+	var c cursor
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, ErrInvalidCursor
+	}
+	if err := json.Unmarshal(b, &c); err != nil {
+		return c, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// Paginate runs db (which may already carry Where, Joins, Preload, ...) as a keyset query over T.
+//
+// posts, err := pagination.Paginate[Post](db.Where("user_id = ?", 1), pagination.Keyset{Desc: true, Limit: 10})
+func Paginate[T any](db *gorm.DB, k Keyset) (*KeysetPage[T], error) {
+	if k.Limit <= 0 {
+		return nil, fmt.Errorf("pagination: limit must be positive, got %d", k.Limit)
+	}
+	if k.Column == "" {
+		k.Column = "created_at"
+	}
+
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(new(T)); err != nil {
+		return nil, err
+	}
+	timeField := stmt.Schema.LookUpField(k.Column)
+	pkField := stmt.Schema.PrioritizedPrimaryField
+	if timeField == nil || pkField == nil {
+		return nil, fmt.Errorf("pagination: %s needs a %q column and a single primary key", stmt.Schema.Name, k.Column)
+	}
+
+	var (
+		c   cursor
+		err error
+	)
+	if k.Cursor != "" {
+		if c, err = decodeCursor(k.Cursor); err != nil {
+			return nil, err
+		}
+	}
+
+	timeCol := clause.Column{Table: clause.CurrentTable, Name: timeField.DBName}
+	pkCol := clause.Column{Table: clause.CurrentTable, Name: pkField.DBName}
+
+	// walking backward flips the scan direction, the page is reversed afterwards
+	desc := k.Desc != c.Backward
+
+	tx := db.Session(&gorm.Session{})
+	if k.Cursor != "" {
+		op := ">"
+		if desc {
+			op = "<"
+		}
+		tx = tx.Where(fmt.Sprintf("(?, ?) %s (?, ?)", op), timeCol, pkCol, c.Time, c.ID)
+	}
+
+	// fetch one extra row to find out whether there is another page
+	var items []T
+	if err := tx.
+		Order(clause.OrderBy{Columns: []clause.OrderByColumn{
+			{Column: timeCol, Desc: desc},
+			{Column: pkCol, Desc: desc},
+		}}).
+		Limit(k.Limit + 1).
+		Find(&items).Error; err != nil {
+		return nil, err
+	}
+
+	more := len(items) > k.Limit
+	if more {
+		items = items[:k.Limit]
+	}
+	if c.Backward {
+		for i, j := 0, len(items)-1; i < j; i, j = i+1, j-1 {
+			items[i], items[j] = items[j], items[i]
+		}
+	}
+
+	page := &KeysetPage[T]{Items: items}
+	if len(items) == 0 {
+		return page, nil
+	}
+
+	first, err := cursorOf(db, timeField, pkField, &items[0])
+	if err != nil {
+		return nil, err
+	}
+	last, err := cursorOf(db, timeField, pkField, &items[len(items)-1])
+	if err != nil {
+		return nil, err
+	}
+
+	// forward: there is a previous page as soon as we came from a cursor
+	// backward: there is always a next page, the one we came from
+	hasNext, hasPrev := more, k.Cursor != ""
+	if c.Backward {
+		hasNext, hasPrev = true, more
+	}
+	if hasNext {
+		page.NextCursor = last.encode()
+	}
+	if hasPrev {
+		first.Backward = true
+		page.PrevCursor = first.encode()
+	}
+
+	return page, nil
+}
+
+func cursorOf(db *gorm.DB, timeField, pkField *schema.Field, item any) (cursor, error) {
+	rv := reflect.ValueOf(item).Elem()
+	ctx := db.Statement.Context
+
+	var t time.Time
+	switch tv, _ := timeField.ValueOf(ctx, rv); v := tv.(type) {
+	case time.Time:
+		t = v
+	case *time.Time:
+		if v == nil {
+			return cursor{}, fmt.Errorf("pagination: %s is NULL, filter those rows out", timeField.Name)
+		}
+		t = *v
+	default:
+		return cursor{}, fmt.Errorf("pagination: %s is not a time.Time", timeField.Name)
+	}
+
+	pv, _ := pkField.ValueOf(ctx, rv)
+	id := reflect.ValueOf(pv)
+	switch id.Kind() {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return cursor{Time: t, ID: id.Uint()}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return cursor{Time: t, ID: uint64(id.Int())}, nil
+	default:
+		return cursor{}, fmt.Errorf("pagination: primary key %s is not an integer", pkField.Name)
+	}
+}
@@ -0,0 +1,163 @@
+package pagination
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// openKeysetDB returns a database of 7 items over 3 timestamps, so pages of 2 or 3 split the ties.
+func openKeysetDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db := openTestDB(t, 0)
+	base := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	items := make([]item, 7)
+	for i := range items {
+		// ids 1-3 share the first timestamp, 4-5 the second, 6-7 the third
+		at := base
+		switch {
+		case i >= 5:
+			at = base.Add(2 * time.Hour)
+		case i >= 3:
+			at = base.Add(time.Hour)
+		}
+		items[i] = item{Name: fmt.Sprintf("item %d", i+1), CreatedAt: at}
+	}
+	if err := db.Create(&items).Error; err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func idsOf(items []item) []uint {
+	ids := []uint{}
+	for _, it := range items {
+		ids = append(ids, it.ID)
+	}
+	return ids
+}
+
+func TestPaginateRoundTrip(t *testing.T) {
+	tests := []struct {
+		name      string
+		desc      bool
+		limit     int
+		wantPages [][]uint
+	}{
+		{"newest first", true, 2, [][]uint{{7, 6}, {5, 4}, {3, 2}, {1}}},
+		{"oldest first", false, 2, [][]uint{{1, 2}, {3, 4}, {5, 6}, {7}}},
+		{"full last page", true, 7, [][]uint{{7, 6, 5, 4, 3, 2, 1}}},
+		{"pages of 3", false, 3, [][]uint{{1, 2, 3}, {4, 5, 6}, {7}}},
+	}
+	db := openKeysetDB(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// forward with NextCursor until the last page
+			var pages []*KeysetPage[item]
+			cursor := ""
+			for {
+				page, err := Paginate[item](db, Keyset{Desc: tt.desc, Cursor: cursor, Limit: tt.limit})
+				if err != nil {
+					t.Fatal(err)
+				}
+				pages = append(pages, page)
+				if page.NextCursor == "" {
+					break
+				}
+				if len(pages) > len(tt.wantPages) {
+					t.Fatalf("more than the %d expected pages", len(tt.wantPages))
+				}
+				cursor = page.NextCursor
+			}
+			if len(pages) != len(tt.wantPages) {
+				t.Fatalf("got %d pages, want %d", len(pages), len(tt.wantPages))
+			}
+			for i, page := range pages {
+				if got := idsOf(page.Items); fmt.Sprint(got) != fmt.Sprint(tt.wantPages[i]) {
+					t.Errorf("page %d forward: %v, want %v", i+1, got, tt.wantPages[i])
+				}
+				if hasPrev := page.PrevCursor != ""; hasPrev != (i > 0) {
+					t.Errorf("page %d forward: has prev %v, want %v", i+1, hasPrev, i > 0)
+				}
+			}
+
+			// and back with PrevCursor to the first page
+			for i := len(pages) - 1; i > 0; i-- {
+				page, err := Paginate[item](db, Keyset{Desc: tt.desc, Cursor: pages[i].PrevCursor, Limit: tt.limit})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := idsOf(page.Items); fmt.Sprint(got) != fmt.Sprint(tt.wantPages[i-1]) {
+					t.Errorf("page %d backward: %v, want %v", i, got, tt.wantPages[i-1])
+				}
+				if page.NextCursor == "" {
+					t.Errorf("page %d backward: no next cursor", i)
+				}
+				if hasPrev := page.PrevCursor != ""; hasPrev != (i > 1) {
+					t.Errorf("page %d backward: has prev %v, want %v", i, hasPrev, i > 1)
+				}
+			}
+		})
+	}
+}
+
+func TestPaginateEmpty(t *testing.T) {
+	db := openKeysetDB(t)
+	page, err := Paginate[item](db.Where("id > ?", 7), Keyset{Desc: true, Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Items) != 0 || page.NextCursor != "" || page.PrevCursor != "" {
+		t.Fatalf("got %+v, want an empty page without cursors", page)
+	}
+
+	// past the last row, the cursor of the last item has nothing after it
+	last := cursor{Time: time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC), ID: 1}.encode()
+	page, err = Paginate[item](db, Keyset{Desc: true, Cursor: last, Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Items) != 0 || page.NextCursor != "" {
+		t.Fatalf("got %+v, want an empty page without a next cursor", page)
+	}
+}
+
+func TestPaginateRejects(t *testing.T) {
+	db := openKeysetDB(t)
+	first, err := Paginate[item](db, Keyset{Desc: true, Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	valid := first.NextCursor
+
+	tests := []struct {
+		name   string
+		keyset Keyset
+		want   error // nil for an error other than ErrInvalidCursor
+	}{
+		{"not base64", Keyset{Cursor: "not a cursor!", Limit: 2}, ErrInvalidCursor},
+		{"padded base64", Keyset{Cursor: base64.URLEncoding.EncodeToString([]byte(`{"id":1}`)), Limit: 2}, ErrInvalidCursor},
+		{"not json", Keyset{Cursor: base64.RawURLEncoding.EncodeToString([]byte("id=1")), Limit: 2}, ErrInvalidCursor},
+		{"truncated", Keyset{Cursor: valid[:len(valid)/2], Limit: 2}, ErrInvalidCursor},
+		{"bad time", Keyset{Cursor: base64.RawURLEncoding.EncodeToString([]byte(`{"t":"yesterday","id":1}`)), Limit: 2}, ErrInvalidCursor},
+		{"negative id", Keyset{Cursor: base64.RawURLEncoding.EncodeToString([]byte(`{"t":"2024-01-01T10:00:00Z","id":-1}`)), Limit: 2}, ErrInvalidCursor},
+		{"zero limit", Keyset{Cursor: valid, Limit: 0}, nil},
+		{"unknown column", Keyset{Column: "published_at", Limit: 2}, nil},
+		{"not a time column", Keyset{Column: "name", Limit: 2}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page, err := Paginate[item](db, tt.keyset)
+			if err == nil {
+				t.Fatalf("got %+v, want an error", page)
+			}
+			if isCursor := errors.Is(err, ErrInvalidCursor); isCursor != (tt.want != nil) {
+				t.Fatalf("got %v, want ErrInvalidCursor %v", err, tt.want != nil)
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+// Offset pagination with totals
+//
+// Numbered pages ("page 2 of 7") need the total number of rows, counted on the same filtered query:
+//
+// SELECT count(*) FROM users WHERE email LIKE '%@gmail.com';                                   -- Total
+// SELECT * FROM users WHERE email LIKE '%@gmail.com' ORDER BY created_at DESC LIMIT 10 OFFSET 10; -- Items
+//
+// The count drops the ORDER BY, LIMIT and OFFSET of the query, they don't change the total and only slow it down.
+// Counting is a second scan of every matching row, an infinite scroll that only needs "is there more"
+// sets SkipCount: one extra row is fetched instead, and Total and TotalPages are left at -1.
+//
+// Unlike the basis.paginate scope, out of range requests are rejected with ErrInvalidPage rather than clamped,
+// so the page the caller renders is the page it asked for. That includes a page past the last one, except page 1
+// which is always valid and empty when nothing matches. See keyset.go for large tables.
+
+package pagination
+
+import (
+	"errors"
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+var ErrInvalidPage = errors.New("invalid page")
+
+// MaxPageSize bounds Offset.Size.
+const MaxPageSize = 100
+
+// Offset describes a numbered page request over the query it is applied to.
+type Offset struct {
+	Page      int  // 1-based
+	Size      int  // rows per page, 1 to MaxPageSize
+	SkipCount bool // don't count, Total and TotalPages are -1
+}
+
+// Page is one page of an offset query.
+type Page[T any] struct {
+	Items      []T
+	Total      int64 // matching rows, -1 with SkipCount
+	Page       int
+	Size       int
+	TotalPages int // at least 1, -1 with SkipCount
+	HasNext    bool
+}
+
+// PaginateOffset runs db (which may already carry Where, Order, Preload, ...) as an offset query over T.
+//
+// page, err := pagination.PaginateOffset[User](db.Where("status = ?", "active").Order("id"), pagination.Offset{Page: 2, Size: 10})
+func PaginateOffset[T any](db *gorm.DB, o Offset) (*Page[T], error) {
+	if o.Page < 1 {
+		return nil, fmt.Errorf("%w: page must be at least 1, got %d", ErrInvalidPage, o.Page)
+	}
+	if o.Size < 1 || o.Size > MaxPageSize {
+		return nil, fmt.Errorf("%w: size must be between 1 and %d, got %d", ErrInvalidPage, MaxPageSize, o.Size)
+	}
+
+	page := &Page[T]{Items: []T{}, Total: -1, Page: o.Page, Size: o.Size, TotalPages: -1}
+	offset := (o.Page - 1) * o.Size
+
+	if o.SkipCount {
+		// fetch one extra row to find out whether there is another page
+		if err := db.Session(&gorm.Session{}).Offset(offset).Limit(o.Size + 1).Find(&page.Items).Error; err != nil {
+			return nil, err
+		}
+		if len(page.Items) == 0 && o.Page > 1 {
+			return nil, fmt.Errorf("%w: page %d is past the last page", ErrInvalidPage, o.Page)
+		}
+		if page.HasNext = len(page.Items) > o.Size; page.HasNext {
+			page.Items = page.Items[:o.Size]
+		}
+		return page, nil
+	}
+
+	// a copy of the query, the clauses removed below stay on db
+	count := db.Session(&gorm.Session{}).Model(new(T))
+	delete(count.Statement.Clauses, "ORDER BY")
+	delete(count.Statement.Clauses, "LIMIT")
+	if !count.Statement.Distinct {
+		count.Statement.Selects = nil // the columns of the items, not what to count
+	}
+	if err := count.Count(&page.Total).Error; err != nil {
+		return nil, err
+	}
+	page.TotalPages = max(int((page.Total+int64(o.Size)-1)/int64(o.Size)), 1)
+	if o.Page > page.TotalPages {
+		return nil, fmt.Errorf("%w: page %d is past the last page %d", ErrInvalidPage, o.Page, page.TotalPages)
+	}
+	page.HasNext = o.Page < page.TotalPages
+
+	if page.Total == 0 {
+		return page, nil // nothing to fetch
+	}
+	if err := db.Session(&gorm.Session{}).Offset(offset).Limit(o.Size).Find(&page.Items).Error; err != nil {
+		return nil, err
+	}
+	return page, nil
+}
@@ -0,0 +1,136 @@
+package pagination
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+type item struct {
+	ID        uint
+	Name      string
+	CreatedAt time.Time
+}
+
+// openTestDB returns an in-memory database holding n items.
+func openTestDB(t *testing.T, n int) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a single connection, every new connection would open another empty in-memory database
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+
+	if err := db.AutoMigrate(&item{}); err != nil {
+		t.Fatal(err)
+	}
+	if n == 0 {
+		return db
+	}
+	items := make([]item, n)
+	for i := range items {
+		items[i] = item{Name: fmt.Sprintf("item %d", i+1)}
+	}
+	if err := db.Create(&items).Error; err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestPaginateOffset(t *testing.T) {
+	tests := []struct {
+		name      string
+		rows      int
+		offset    Offset
+		wantIDs   []uint
+		wantTotal int64
+		wantPages int
+		wantNext  bool
+	}{
+		{"first page", 5, Offset{Page: 1, Size: 2}, []uint{1, 2}, 5, 3, true},
+		{"last page", 5, Offset{Page: 3, Size: 2}, []uint{5}, 5, 3, false},
+		{"full last page", 4, Offset{Page: 2, Size: 2}, []uint{3, 4}, 4, 2, false},
+		{"largest size", 5, Offset{Page: 1, Size: MaxPageSize}, []uint{1, 2, 3, 4, 5}, 5, 1, false},
+		{"nothing matches", 0, Offset{Page: 1, Size: 2}, []uint{}, 0, 1, false},
+		{"skip count", 5, Offset{Page: 2, Size: 2, SkipCount: true}, []uint{3, 4}, -1, -1, true},
+		{"skip count, last page", 5, Offset{Page: 3, Size: 2, SkipCount: true}, []uint{5}, -1, -1, false},
+		{"skip count, nothing matches", 0, Offset{Page: 1, Size: 2, SkipCount: true}, []uint{}, -1, -1, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := openTestDB(t, tt.rows)
+			page, err := PaginateOffset[item](db.Order("id"), tt.offset)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ids := []uint{}
+			for _, it := range page.Items {
+				ids = append(ids, it.ID)
+			}
+			if fmt.Sprint(ids) != fmt.Sprint(tt.wantIDs) {
+				t.Errorf("items %v, want %v", ids, tt.wantIDs)
+			}
+			if page.Total != tt.wantTotal || page.TotalPages != tt.wantPages || page.HasNext != tt.wantNext {
+				t.Errorf("total %d, pages %d, has next %v, want %d, %d, %v",
+					page.Total, page.TotalPages, page.HasNext, tt.wantTotal, tt.wantPages, tt.wantNext)
+			}
+			if page.Page != tt.offset.Page || page.Size != tt.offset.Size {
+				t.Errorf("page %d of size %d, want %d of size %d", page.Page, page.Size, tt.offset.Page, tt.offset.Size)
+			}
+		})
+	}
+}
+
+func TestPaginateOffsetCountsTheFilteredQuery(t *testing.T) {
+	db := openTestDB(t, 5)
+	// the Select and Limit of the query must not leak into the count
+	page, err := PaginateOffset[item](db.Select("id").Where("id > ?", 1).Order("id DESC").Limit(1), Offset{Page: 2, Size: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Total != 4 || page.TotalPages != 2 || page.HasNext {
+		t.Fatalf("total %d, pages %d, has next %v, want 4, 2, false", page.Total, page.TotalPages, page.HasNext)
+	}
+	if len(page.Items) != 1 || page.Items[0].ID != 2 || page.Items[0].Name != "" {
+		t.Fatalf("items %+v, want only the id of item 2", page.Items)
+	}
+}
+
+func TestPaginateOffsetRejects(t *testing.T) {
+	tests := []struct {
+		name    string
+		offset  Offset
+		wantMsg string
+	}{
+		{"page 0", Offset{Page: 0, Size: 2}, "invalid page: page must be at least 1, got 0"},
+		{"negative page", Offset{Page: -1, Size: 2}, "invalid page: page must be at least 1, got -1"},
+		{"size 0", Offset{Page: 1, Size: 0}, "invalid page: size must be between 1 and 100, got 0"},
+		{"negative size", Offset{Page: 1, Size: -5}, "invalid page: size must be between 1 and 100, got -5"},
+		{"size above the maximum", Offset{Page: 1, Size: MaxPageSize + 1}, "invalid page: size must be between 1 and 100, got 101"},
+		{"page past the end", Offset{Page: 4, Size: 2}, "invalid page: page 4 is past the last page 3"},
+		{"skip count, page past the end", Offset{Page: 4, Size: 2, SkipCount: true}, "invalid page: page 4 is past the last page"},
+	}
+	db := openTestDB(t, 5)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page, err := PaginateOffset[item](db.Order("id"), tt.offset)
+			if !errors.Is(err, ErrInvalidPage) {
+				t.Fatalf("got %+v, %v, want ErrInvalidPage", page, err)
+			}
+			if err.Error() != tt.wantMsg {
+				t.Errorf("error %q, want %q", err, tt.wantMsg)
+			}
+		})
+	}
+}
@@ -0,0 +1,303 @@
+// Post view analytics
+//
+// Every view of a post is appended to post_views, one row per view:
+//
+// | id | post_id | user_id | viewed_at           | referrer               |
+// | -- | ------- | ------- | ------------------- | ---------------------- |
+// | 1  | 7       | 2       | 2024-01-01 10:04:00 | https://news.ycomb...  |
+// | 2  | 7       | NULL    | 2024-01-01 10:31:00 |                        |
+//
+// Rows are never updated. Raw views older than a few days are rolled up by ViewRollup into two summary tables,
+// at an hour granularity, and deleted so post_views stays small:
+//
+// post_view_hourlies: (post_id, hour) -> views
+// post_view_viewers:  (post_id, hour, user_id), one row per signed-in viewer and hour
+//
+// The statistics read both sides, raw views for the recent hours and the summaries for older ones,
+// so rolling up doesn't change any result. Buckets (hour, day, week starting on Monday) are in UTC.
+// Unique viewers are the distinct signed-in users, anonymous views have no identity and are only counted as views.
+//
+// Times are stored in UTC: the bucketing is done with SQLite's strftime and ranges are compared as text.
+
+package project
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var ErrPostViewImmutable = errors.New("post views are append-only")
+
+type PostView struct {
+	ID       uint      `gorm:"primaryKey"`
+	PostID   uint      `gorm:"not null;index:idx_view_post_viewed,priority:1"` // FK
+	UserID   *uint     // FK, NULL for anonymous views
+	ViewedAt time.Time `gorm:"not null;index:idx_view_post_viewed,priority:2;index"` // UTC
+	Referrer string    `gorm:"size:255"`
+}
+
+type PostViewHourly struct {
+	PostID uint      `gorm:"primaryKey"`
+	Hour   time.Time `gorm:"primaryKey;index"` // UTC, start of the hour
+	Views  int64     `gorm:"not null"`
+}
+
+type PostViewViewer struct {
+	PostID uint      `gorm:"primaryKey"`
+	Hour   time.Time `gorm:"primaryKey;index"` // UTC, start of the hour
+	UserID uint      `gorm:"primaryKey"`
+}
+
+type ViewBucket string
+
+const (
+	BucketHour ViewBucket = "hour"
+	BucketDay  ViewBucket = "day"
+	BucketWeek ViewBucket = "week"
+)
+
+// bucketExpr returns the SQL truncating column to the start of its bucket, as "YYYY-MM-DD HH:MM:SS".
+func bucketExpr(bucket ViewBucket, column string) (string, error) {
+	switch bucket {
+	case BucketHour:
+		return fmt.Sprintf("strftime('%%Y-%%m-%%d %%H:00:00', %s)", column), nil
+	case BucketDay:
+		return fmt.Sprintf("strftime('%%Y-%%m-%%d 00:00:00', %s)", column), nil
+	case BucketWeek:
+		// the next Sunday (or the day itself), then back to its Monday
+		return fmt.Sprintf("strftime('%%Y-%%m-%%d 00:00:00', %s, 'weekday 0', '-6 days')", column), nil
+	default:
+		return "", fmt.Errorf("%w: unknown bucket %q", ErrInvalidInput, bucket)
+	}
+}
+
+const bucketLayout = "2006-01-02 15:04:05"
+
+// ViewStat counts the views of a bucket, Start is its first instant.
+type ViewStat struct {
+	Start         time.Time
+	Views         int64
+	UniqueViewers int64
+}
+
+// PostViewCount ranks a post by its views over a window, see TopPosts.
+type PostViewCount struct {
+	PostID        uint
+	Subject       string
+	Views         int64
+	UniqueViewers int64
+}
+
+func (v *PostView) BeforeUpdate(tx *gorm.DB) error {
+	return ErrPostViewImmutable
+}
+
+// RecordView appends a view of a visible post, userID is 0 for anonymous readers.
+func RecordView(db *gorm.DB, postID, userID uint, referrer string) error {
+	if err := db.Scopes(visiblePosts).Select("id").First(&Post{}, postID).Error; err != nil {
+		return err
+	}
+
+	if r := []rune(referrer); len(r) > 255 {
+		referrer = string(r[:255])
+	}
+	v := PostView{PostID: postID, ViewedAt: db.NowFunc().UTC(), Referrer: referrer}
+	if userID != 0 {
+		v.UserID = &userID
+	}
+	return db.Create(&v).Error
+}
+
+// viewRows is the union of the raw views and the summaries within [from, to), one row per
+// raw view (views 1), hourly summary (no viewer) or summarized viewer (views 0).
+func viewRows(db *gorm.DB, bucket ViewBucket, from, to time.Time) (*gorm.DB, error) {
+	raw, err := bucketExpr(bucket, "viewed_at")
+	if err != nil {
+		return nil, err
+	}
+	summary, _ := bucketExpr(bucket, "hour")
+	from, to = from.UTC(), to.UTC()
+
+	return db.Raw(fmt.Sprintf(`
+		SELECT post_id, %s AS bucket, 1 AS views, user_id FROM post_views WHERE viewed_at >= ? AND viewed_at < ?
+		UNION ALL
+		SELECT post_id, %s AS bucket, views, NULL FROM post_view_hourlies WHERE hour >= ? AND hour < ?
+		UNION ALL
+		SELECT post_id, %s AS bucket, 0, user_id FROM post_view_viewers WHERE hour >= ? AND hour < ?
+	`, raw, summary, summary), from, to, from, to, from, to), nil
+}
+
+// ViewStats counts the views and unique viewers of a post per bucket within [from, to), oldest bucket first.
+// Buckets without views are left out. Rolled up views count for their whole hour.
+func ViewStats(db *gorm.DB, postID uint, bucket ViewBucket, from, to time.Time) ([]ViewStat, error) {
+	rows, err := viewRows(db, bucket, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	var counts []struct {
+		Bucket        string
+		Views         int64
+		UniqueViewers int64
+	}
+	if err := db.Table("(?) AS v", rows).
+		Select("bucket, SUM(views) AS views, COUNT(DISTINCT user_id) AS unique_viewers").
+		Where("post_id = ?", postID).
+		Group("bucket").
+		Order("bucket").
+		Scan(&counts).Error; err != nil {
+		return nil, err
+	}
+
+	stats := make([]ViewStat, len(counts))
+	for i, c := range counts {
+		start, err := time.ParseInLocation(bucketLayout, c.Bucket, time.UTC)
+		if err != nil {
+			return nil, err
+		}
+		stats[i] = ViewStat{Start: start, Views: c.Views, UniqueViewers: c.UniqueViewers}
+	}
+	return stats, nil
+}
+
+// TopPosts returns the number most viewed visible posts within [from, to), most viewed first.
+func TopPosts(db *gorm.DB, from, to time.Time, number int) ([]PostViewCount, error) {
+	rows, err := viewRows(db, BucketHour, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	var top []PostViewCount
+	if err := db.Table("(?) AS v", rows).
+		Select("v.post_id, posts.subject, SUM(v.views) AS views, COUNT(DISTINCT v.user_id) AS unique_viewers").
+		Joins("JOIN posts ON posts.id = v.post_id AND posts.deleted_at IS NULL").
+		Scopes(visiblePosts).
+		Group("v.post_id, posts.subject").
+		Order("views DESC, v.post_id").
+		Limit(number).
+		Scan(&top).Error; err != nil {
+		return nil, err
+	}
+	return top, nil
+}
+
+// RollupPostViews moves the raw views older than before into the summary tables and returns how many were
+// rolled up. It runs in one transaction, the statistics never see a view twice or not at all.
+func RollupPostViews(db *gorm.DB, before time.Time) (int64, error) {
+	before = before.UTC()
+	var rolled int64
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// the views to roll up are fixed first, the statements below all work on the same rows
+		var maxID uint
+		if err := tx.Model(&PostView{}).
+			Where("viewed_at < ?", before).
+			Select("COALESCE(MAX(id), 0)").
+			Scan(&maxID).Error; err != nil {
+			return err // roll back
+		}
+		if maxID == 0 {
+			return nil
+		}
+
+		// formatted like the timestamps written by the driver, so ranges keep comparing as text
+		hour := "strftime('%Y-%m-%d %H:00:00+00:00', viewed_at)"
+		if err := tx.Exec(`
+			INSERT INTO post_view_hourlies (post_id, hour, views)
+			SELECT post_id, `+hour+`, COUNT(*) FROM post_views
+			WHERE id <= ? AND viewed_at < ?
+			GROUP BY 1, 2
+			ON CONFLICT (post_id, hour) DO UPDATE SET views = post_view_hourlies.views + excluded.views
+		`, maxID, before).Error; err != nil {
+			return err // roll back
+		}
+
+		if err := tx.Exec(`
+			INSERT INTO post_view_viewers (post_id, hour, user_id)
+			SELECT DISTINCT post_id, `+hour+`, user_id FROM post_views
+			WHERE id <= ? AND viewed_at < ? AND user_id IS NOT NULL
+			ON CONFLICT (post_id, hour, user_id) DO NOTHING
+		`, maxID, before).Error; err != nil {
+			return err // roll back
+		}
+
+		result := tx.Where("id <= ? AND viewed_at < ?", maxID, before).Delete(&PostView{})
+		rolled = result.RowsAffected
+		return result.Error
+	})
+	return rolled, err
+}
+
+// ViewRollup rolls up the raw views once they are older than keep.
+// Nothing in this package starts it, whoever runs Run owns the goroutine: blog serve starts one next to the API.
+type ViewRollup struct {
+	db       *gorm.DB
+	interval time.Duration
+	keep     time.Duration
+}
+
+func NewViewRollup(db *gorm.DB, interval, keep time.Duration) *ViewRollup {
+	return &ViewRollup{db: db, interval: interval, keep: keep}
+}
+
+// Run rolls up old views every interval until ctx is done.
+func (r *ViewRollup) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := r.RollupOld(ctx); err != nil {
+			r.db.Logger.Error(ctx, "failed to roll up post views, %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RollupOld rolls up the views older than keep and returns how many were rolled up.
+func (r *ViewRollup) RollupOld(ctx context.Context) (int64, error) {
+	db := r.db.WithContext(ctx)
+	return RollupPostViews(db, db.NowFunc().Add(-r.keep))
+}
+
+func PostViewTest(db *gorm.DB) {
+	for userID := uint(0); userID <= 3; userID++ {
+		if err := RecordView(db, 1, userID, "https://example.com"); err != nil {
+			panic(err)
+		}
+	}
+	if err := RecordView(db, 2, 1, ""); err != nil {
+		panic(err)
+	}
+
+	to := time.Now().Add(time.Hour)
+	from := to.Add(-7 * 24 * time.Hour)
+	before, err := ViewStats(db, 1, BucketDay, from, to)
+	if err != nil {
+		panic(err)
+	}
+
+	// roll up everything, the statistics don't change
+	rolled, err := NewViewRollup(db, time.Hour, -time.Minute).RollupOld(context.Background())
+	if err != nil {
+		panic(err)
+	}
+	after, err := ViewStats(db, 1, BucketDay, from, to)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("rolled up %d views, before %+v, after %+v\n", rolled, before, after)
+
+	top, err := TopPosts(db, from, to, 3)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("top posts: %+v\n", top)
+}
@@ -0,0 +1,1367 @@
+// The blog API exposes the project domain over HTTP with JSON bodies.
+//
+// +--------+------------------------------+--------------------------------------+
+// | Method | Path                         | Description                          |
+// +--------+------------------------------+--------------------------------------+
+// | GET    | /posts?limit=N               | latest posts of all users            |
+// | POST   | /posts                       | create a post without tags           |
+// | POST   | /posts/publish               | publish a post with tags             |
+// | POST   | /posts/{id}/publish          | publish a draft/scheduled/archived   |
+// | POST   | /posts/{id}/schedule         | schedule a draft, body {"At": ...}   |
+// | POST   | /posts/{id}/archive          | archive a published post             |
+// | POST   | /posts/{id}/draft            | take a post back to draft            |
+// | GET    | /posts/search?q=...          | full-text search, optional &tag=ID   |
+// |        |   [&user_id=&page=&limit=]   | (repeatable), author and page        |
+// | GET    | /posts/{id}                  | a post with its tags and comments    |
+// | POST   | /posts/{id}/views            | record a view, with the Referer      |
+// | GET    | /posts/{id}/views?bucket=day | views and unique viewers per hour,   |
+// |        |   [&from=&to=]               | day or week (RFC 3339, last 7 days)  |
+// | GET    | /posts/top?limit=N           | most viewed posts, same window       |
+// |        |   [&from=&to=]               |                                      |
+// | PATCH  | /posts/{id}                  | edit, body {"Subject", "Content"}    |
+// | DELETE | /posts/{id}                  | soft delete a post and its comments  |
+// | POST   | /posts/{id}/restore          | restore a deleted post               |
+// | GET    | /posts/{id}/revisions        | revisions of a post, latest first    |
+// | GET    | /posts/{id}/revisions/diff   | line diff between two revisions      |
+// |        |   ?from=N&to=M               |                                      |
+// | POST   | /posts/{id}/revisions/{n}/   | restore revision n as a new revision |
+// |        |   restore                    |                                      |
+// | GET    | /posts/{id}/comments         | comment thread in depth-first order  |
+// | POST   | /posts/{id}/comments         | add a comment to a post              |
+// | POST   | /comments/{id}/replies       | reply to a comment                   |
+// | DELETE | /comments/{id}[?hard=true]   | soft (default) or hard delete, by    |
+// |        |                              | authors of comment/post, moderators  |
+// | GET    | /comments/pending?limit=N    | moderation queue, oldest first       |
+// | POST   | /comments/{id}/approve       | approve a pending/rejected comment   |
+// | POST   | /comments/{id}/reject        | reject a comment {"Reason": ...}     |
+// | GET    | /posts/{id}/reactions        | reaction counts by kind              |
+// | PUT    | /posts/{id}/reactions        | react to a post, body {"Kind": ...}  |
+// | DELETE | /posts/{id}/reactions        | remove the caller's reaction         |
+// | GET    | /comments/{id}/reactions     | same for comments                    |
+// | PUT    | /comments/{id}/reactions     |                                      |
+// | DELETE | /comments/{id}/reactions     |                                      |
+// | GET    | /tags?limit=N                | tag cloud, post counts per tag       |
+// | GET    | /tags/{slug}/posts?limit=N   | latest posts carrying a tag          |
+// | PATCH  | /tags/{id}                   | rename a tag, body {"Name": ...}     |
+// | POST   | /tags/{id}/merge             | merge into another tag {"IntoID": 1} |
+// | GET    | /users/{id}/posts?limit=N    | latest posts of a user, paginated    |
+// |        |   [&cursor=...]              | with NextCursor/PrevCursor           |
+// | GET    | /users/{id}/followers        | users following a user               |
+// | GET    | /users/{id}/following        | users followed by a user             |
+// | PUT    | /users/{id}/follow           | follow a user                        |
+// | DELETE | /users/{id}/follow           | unfollow a user                      |
+// | GET    | /feed?limit=N[&cursor=...]   | home feed of the caller, paginated,  |
+// |        |   [&materialized=true]       | optionally read from feed_items      |
+// | GET    | /rss, /atom                  | site feed, see syndication.go        |
+// | GET    | /users/{id}/rss, .../atom    | feed of a user's posts               |
+// | GET    | /tags/{slug}/rss, .../atom   | feed of the posts carrying a tag     |
+// +--------+------------------------------+--------------------------------------+
+//
+// API.Authenticate identifies the caller of a request, requests it doesn't identify are anonymous and can only read.
+// HeaderAuth trusts an X-User-ID header, which anyone can set: only use it behind a gateway that authenticates
+// the users and sets the header itself, or in tests.
+//
+// The caller is the author of the posts, comments and replies it creates. It is required to edit, delete or
+// change the status of a post (only its author may) and lets authors see their own unpublished posts on the read paths.
+// Edits record the caller as the editor of the new revision.
+// It is also required to react, to follow and to read the home feed. The moderation endpoints are reserved
+// to moderators (the caller is recorded as the moderator), who can't moderate their own comments,
+// and so are the tag renames and merges.
+//
+// The read paths returning posts (GET /posts, /posts/{id}, /posts/search, /tags/{slug}/posts, /users/{id}/posts
+// and /feed) accept ?format=markdown (default) or ?format=html: with html, Content holds the sanitized HTML
+// rendered from the Markdown instead of the Markdown itself.
+//
+// The RSS and Atom feeds support conditional GET: they carry an ETag (a hash of the document) and a Last-Modified
+// (the latest update of their posts), If-None-Match and If-Modified-Since are answered with 304 Not Modified.
+//
+// Errors are returned as {"error": "..."} with a status code derived from the error:
+// ErrUnauthenticated -> 401, gorm.ErrRecordNotFound -> 404, gorm.ErrDuplicatedKey, ErrInvalidTransition and ErrAlreadyModerated -> 409, ErrForbidden -> 403,
+// ErrInvalidInput and pagination.ErrInvalidCursor -> 400, ErrSearchUnavailable -> 501,
+// *RateLimitError -> 429 with a Retry-After header in seconds.
+
+package project
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"gorm/pagination"
+
+	"gorm.io/gorm"
+)
+
+const (
+	defaultLimit = 10
+	maxLimit     = 100
+)
+
+// ErrUnauthenticated is returned when a request needs a caller but Authenticate didn't identify one.
+var ErrUnauthenticated = errors.New("unauthenticated")
+
+type API struct {
+	// Authenticate returns the ID of the user making the request, 0 for an anonymous request.
+	// Nil leaves every request anonymous. Errors are reported as they are, wrap ErrUnauthenticated for a 401.
+	Authenticate func(r *http.Request) (uint, error)
+
+	// Config applies to every request, DefaultConfig unless changed.
+	Config Config
+
+	db  *gorm.DB
+	mux *http.ServeMux
+}
+
+type createPostRequest struct {
+	Subject string
+	Content string
+}
+
+type publishPostRequest struct {
+	Subject string
+	Content string
+	TagIDs  []uint
+}
+
+type updatePostRequest struct {
+	Subject string
+	Content string
+}
+
+type schedulePostRequest struct {
+	At time.Time
+}
+
+type renameTagRequest struct {
+	Name string
+}
+
+type mergeTagRequest struct {
+	IntoID uint
+}
+
+type addCommentRequest struct {
+	Content string
+}
+
+type reactRequest struct {
+	Kind ReactionKind
+}
+
+type rejectCommentRequest struct {
+	Reason string
+}
+
+// NewAPI builds the HTTP handler of the blog on top of db.
+// The schema must already be migrated, see Migrate. Every request is anonymous until Authenticate is set.
+func NewAPI(db *gorm.DB) *API {
+	a := &API{Config: DefaultConfig(), db: db, mux: http.NewServeMux()}
+
+	a.mux.HandleFunc("GET /posts", a.listPosts)
+	a.mux.HandleFunc("POST /posts", a.createPost)
+	a.mux.HandleFunc("POST /posts/publish", a.publishPost)
+	a.mux.HandleFunc("POST /posts/{id}/publish", a.publishDraft)
+	a.mux.HandleFunc("POST /posts/{id}/schedule", a.schedulePost)
+	a.mux.HandleFunc("POST /posts/{id}/archive", a.archivePost)
+	a.mux.HandleFunc("POST /posts/{id}/draft", a.revertToDraft)
+	a.mux.HandleFunc("GET /posts/search", a.searchPosts)
+	a.mux.HandleFunc("GET /posts/{id}", a.getPost)
+	a.mux.HandleFunc("POST /posts/{id}/views", a.recordView)
+	a.mux.HandleFunc("GET /posts/{id}/views", a.viewStats)
+	a.mux.HandleFunc("GET /posts/top", a.topPosts)
+	a.mux.HandleFunc("PATCH /posts/{id}", a.updatePost)
+	a.mux.HandleFunc("DELETE /posts/{id}", a.deletePost)
+	a.mux.HandleFunc("POST /posts/{id}/restore", a.restorePost)
+	a.mux.HandleFunc("GET /posts/{id}/revisions", a.listRevisions)
+	a.mux.HandleFunc("GET /posts/{id}/revisions/diff", a.diffRevisions)
+	a.mux.HandleFunc("POST /posts/{id}/revisions/{number}/restore", a.restoreRevision)
+	a.mux.HandleFunc("GET /posts/{id}/comments", a.commentThread)
+	a.mux.HandleFunc("POST /posts/{id}/comments", a.addComment)
+	a.mux.HandleFunc("POST /comments/{id}/replies", a.replyToComment)
+	a.mux.HandleFunc("DELETE /comments/{id}", a.deleteComment)
+	a.mux.HandleFunc("GET /comments/pending", a.moderationQueue)
+	a.mux.HandleFunc("POST /comments/{id}/approve", a.approveComment)
+	a.mux.HandleFunc("POST /comments/{id}/reject", a.rejectComment)
+	a.mux.HandleFunc("GET /posts/{id}/reactions", a.reactionSummary(ReactionOnPost))
+	a.mux.HandleFunc("PUT /posts/{id}/reactions", a.react(ReactionOnPost))
+	a.mux.HandleFunc("DELETE /posts/{id}/reactions", a.unreact(ReactionOnPost))
+	a.mux.HandleFunc("GET /comments/{id}/reactions", a.reactionSummary(ReactionOnComment))
+	a.mux.HandleFunc("PUT /comments/{id}/reactions", a.react(ReactionOnComment))
+	a.mux.HandleFunc("DELETE /comments/{id}/reactions", a.unreact(ReactionOnComment))
+	a.mux.HandleFunc("GET /tags", a.tagCloud)
+	a.mux.HandleFunc("GET /tags/{slug}/posts", a.tagPosts)
+	a.mux.HandleFunc("PATCH /tags/{id}", a.renameTag)
+	a.mux.HandleFunc("POST /tags/{id}/merge", a.mergeTag)
+	a.mux.HandleFunc("GET /users/{id}/posts", a.userLatestPosts)
+	a.mux.HandleFunc("GET /users/{id}/followers", a.followers)
+	a.mux.HandleFunc("GET /users/{id}/following", a.following)
+	a.mux.HandleFunc("PUT /users/{id}/follow", a.follow)
+	a.mux.HandleFunc("DELETE /users/{id}/follow", a.unfollow)
+	a.mux.HandleFunc("GET /feed", a.homeFeed)
+	a.mux.HandleFunc("GET /rss", a.syndicate(feedRSS, a.siteSyndication))
+	a.mux.HandleFunc("GET /atom", a.syndicate(feedAtom, a.siteSyndication))
+	a.mux.HandleFunc("GET /users/{id}/rss", a.syndicate(feedRSS, a.userSyndication))
+	a.mux.HandleFunc("GET /users/{id}/atom", a.syndicate(feedAtom, a.userSyndication))
+	a.mux.HandleFunc("GET /tags/{slug}/rss", a.syndicate(feedRSS, a.tagSyndication))
+	a.mux.HandleFunc("GET /tags/{slug}/atom", a.syndicate(feedAtom, a.tagSyndication))
+
+	return a
+}
+
+// HeaderAuth identifies the caller by the X-User-ID header, see the API doc for when it can be trusted.
+func HeaderAuth(r *http.Request) (uint, error) {
+	v := r.Header.Get("X-User-ID")
+	if v == "" {
+		return 0, nil
+	}
+	uid, err := strconv.ParseUint(v, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: invalid X-User-ID %q", ErrUnauthenticated, v)
+	}
+	return uint(uid), nil
+}
+
+func (a *API) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r = r.WithContext(WithConfig(r.Context(), a.Config))
+	if a.Authenticate != nil {
+		uid, err := a.Authenticate(r)
+		if err != nil {
+			a.writeError(w, err)
+			return
+		}
+		if uid != 0 {
+			r = r.WithContext(WithUserID(r.Context(), uid))
+		}
+	}
+
+	a.mux.ServeHTTP(w, r)
+}
+
+func (a *API) listPosts(w http.ResponseWriter, r *http.Request) {
+	limit, err := limitParam(r)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+
+	asHTML, err := htmlFormat(r)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+
+	posts, err := ListPosts(a.db.WithContext(r.Context()), limit)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+	if asHTML {
+		withHTML(posts)
+	}
+	writeJSON(w, http.StatusOK, posts)
+}
+
+func (a *API) createPost(w http.ResponseWriter, r *http.Request) {
+	uid, err := callerID(r, "write posts")
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+	var req createPostRequest
+	if err := decodeBody(r, &req); err != nil {
+		a.writeError(w, err)
+		return
+	}
+
+	p, err := CreatePost(a.db.WithContext(r.Context()), uid, req.Subject, req.Content)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, p)
+}
+
+func (a *API) publishPost(w http.ResponseWriter, r *http.Request) {
+	uid, err := callerID(r, "write posts")
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+	var req publishPostRequest
+	if err := decodeBody(r, &req); err != nil {
+		a.writeError(w, err)
+		return
+	}
+
+	p, err := PublishPostWithTags(a.db.WithContext(r.Context()), uid, req.Subject, req.Content, req.TagIDs)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, p)
+}
+
+// changeStatus runs a lifecycle transition on the post in the path, on behalf of its author.
+func (a *API) changeStatus(w http.ResponseWriter, r *http.Request, change func(db *gorm.DB, postID uint) error) {
+	postID, err := idParam(r)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+
+	db := a.db.WithContext(r.Context())
+	if err := requireAuthor(db, postID, "change the status of"); err != nil {
+		a.writeError(w, err)
+		return
+	}
+
+	if err := change(db, postID); err != nil {
+		a.writeError(w, err)
+		return
+	}
+	var p Post
+	if err := db.Preload("Tags").First(&p, postID).Error; err != nil {
+		a.writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, p)
+}
+
+// requireAuthor checks that the user of the db context wrote the post, what describes the refused action.
+func requireAuthor(db *gorm.DB, postID uint, what string) error {
+	var p Post
+	if err := db.Select("id", "user_id").First(&p, postID).Error; err != nil {
+		return err
+	}
+	if uid, ok := userIDFrom(db.Statement.Context); !ok || uid != p.UserID {
+		return fmt.Errorf("%w: only the author can %s a post", ErrForbidden, what)
+	}
+	return nil
+}
+
+func (a *API) publishDraft(w http.ResponseWriter, r *http.Request) {
+	a.changeStatus(w, r, PublishPost)
+}
+
+func (a *API) schedulePost(w http.ResponseWriter, r *http.Request) {
+	var req schedulePostRequest
+	if err := decodeBody(r, &req); err != nil {
+		a.writeError(w, err)
+		return
+	}
+	if req.At.IsZero() {
+		a.writeError(w, fmt.Errorf("%w: At is required", ErrInvalidInput))
+		return
+	}
+
+	a.changeStatus(w, r, func(db *gorm.DB, postID uint) error {
+		return SchedulePost(db, postID, req.At)
+	})
+}
+
+func (a *API) archivePost(w http.ResponseWriter, r *http.Request) {
+	a.changeStatus(w, r, ArchivePost)
+}
+
+func (a *API) revertToDraft(w http.ResponseWriter, r *http.Request) {
+	a.changeStatus(w, r, RevertToDraft)
+}
+
+func (a *API) searchPosts(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	limit, err := limitParam(r)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+
+	q := SearchQuery{Query: query.Get("q"), Size: limit}
+	if q.Page, err = uintQuery(r, "page"); err != nil {
+		a.writeError(w, err)
+		return
+	}
+	userID, err := uintQuery(r, "user_id")
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+	q.UserID = uint(userID)
+	asHTML, err := htmlFormat(r)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+	for _, v := range query["tag"] {
+		id, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			a.writeError(w, fmt.Errorf("%w: invalid tag %q", ErrInvalidInput, v))
+			return
+		}
+		q.TagIDs = append(q.TagIDs, uint(id))
+	}
+
+	hits, err := SearchPosts(a.db.WithContext(r.Context()), q)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+	if asHTML {
+		for i := range hits {
+			hits[i].Post.Content = hits[i].Post.ContentHTML
+		}
+	}
+	writeJSON(w, http.StatusOK, hits)
+}
+
+func (a *API) getPost(w http.ResponseWriter, r *http.Request) {
+	id, err := idParam(r)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+
+	asHTML, err := htmlFormat(r)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+
+	p, err := GetPost(a.db.WithContext(r.Context()), id)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+	if asHTML {
+		p.Content = p.ContentHTML
+	}
+	writeJSON(w, http.StatusOK, p)
+}
+
+func (a *API) recordView(w http.ResponseWriter, r *http.Request) {
+	id, err := idParam(r)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+
+	uid, _ := userIDFrom(r.Context()) // anonymous views count too
+	if err := RecordView(a.db.WithContext(r.Context()), id, uid, r.Referer()); err != nil {
+		a.writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *API) viewStats(w http.ResponseWriter, r *http.Request) {
+	id, err := idParam(r)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+	from, to, err := a.windowParams(r)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+	bucket := BucketDay
+	if v := r.URL.Query().Get("bucket"); v != "" {
+		bucket = ViewBucket(v)
+	}
+
+	db := a.db.WithContext(r.Context())
+	if err := db.Scopes(visiblePosts).Select("id").First(&Post{}, id).Error; err != nil {
+		a.writeError(w, err)
+		return
+	}
+
+	stats, err := ViewStats(db, id, bucket, from, to)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, stats)
+}
+
+func (a *API) topPosts(w http.ResponseWriter, r *http.Request) {
+	limit, err := limitParam(r)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+	from, to, err := a.windowParams(r)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+
+	top, err := TopPosts(a.db.WithContext(r.Context()), from, to, limit)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, top)
+}
+
+func (a *API) updatePost(w http.ResponseWriter, r *http.Request) {
+	postID, err := idParam(r)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+
+	var req updatePostRequest
+	if err := decodeBody(r, &req); err != nil {
+		a.writeError(w, err)
+		return
+	}
+
+	db := a.db.WithContext(r.Context())
+	if err := requireAuthor(db, postID, "edit"); err != nil {
+		a.writeError(w, err)
+		return
+	}
+
+	p, err := UpdatePost(db, postID, req.Subject, req.Content)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, p)
+}
+
+func (a *API) deletePost(w http.ResponseWriter, r *http.Request) {
+	postID, err := idParam(r)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+
+	db := a.db.WithContext(r.Context())
+	if err := requireAuthor(db, postID, "delete"); err != nil {
+		a.writeError(w, err)
+		return
+	}
+
+	if err := DeletePost(db, postID); err != nil {
+		a.writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *API) restorePost(w http.ResponseWriter, r *http.Request) {
+	postID, err := idParam(r)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+
+	db := a.db.WithContext(r.Context())
+	// Unscoped, the post is deleted
+	if err := requireAuthor(db.Unscoped(), postID, "restore"); err != nil {
+		a.writeError(w, err)
+		return
+	}
+
+	if err := RestorePost(db, postID); err != nil {
+		a.writeError(w, err)
+		return
+	}
+	p, err := GetPost(db, postID)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, p)
+}
+
+func (a *API) listRevisions(w http.ResponseWriter, r *http.Request) {
+	postID, err := idParam(r)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+
+	revs, err := ListRevisions(a.db.WithContext(r.Context()), postID)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, revs)
+}
+
+func (a *API) diffRevisions(w http.ResponseWriter, r *http.Request) {
+	postID, err := idParam(r)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+	from, err := uintQuery(r, "from")
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+	to, err := uintQuery(r, "to")
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+	if from == 0 || to == 0 {
+		a.writeError(w, fmt.Errorf("%w: from and to are required", ErrInvalidInput))
+		return
+	}
+
+	diff, err := DiffRevisions(a.db.WithContext(r.Context()), postID, from, to)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, diff)
+}
+
+func (a *API) restoreRevision(w http.ResponseWriter, r *http.Request) {
+	postID, err := idParam(r)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+	number, err := strconv.Atoi(r.PathValue("number"))
+	if err != nil || number <= 0 {
+		a.writeError(w, fmt.Errorf("%w: invalid revision %q", ErrInvalidInput, r.PathValue("number")))
+		return
+	}
+
+	db := a.db.WithContext(r.Context())
+	if err := requireAuthor(db, postID, "restore"); err != nil {
+		a.writeError(w, err)
+		return
+	}
+
+	rev, err := RestoreRevision(db, postID, number)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, rev)
+}
+
+func (a *API) addComment(w http.ResponseWriter, r *http.Request) {
+	uid, err := callerID(r, "comment")
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+	postID, err := idParam(r)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+
+	var req addCommentRequest
+	if err := decodeBody(r, &req); err != nil {
+		a.writeError(w, err)
+		return
+	}
+
+	c, err := AddComment(a.db.WithContext(r.Context()), postID, uid, req.Content)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, c)
+}
+
+func (a *API) commentThread(w http.ResponseWriter, r *http.Request) {
+	postID, err := idParam(r)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+
+	db := a.db.WithContext(r.Context())
+	if err := db.Scopes(visiblePosts).First(&Post{}, postID).Error; err != nil {
+		a.writeError(w, err)
+		return
+	}
+
+	thread, err := LoadCommentThread(db, postID)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, thread)
+}
+
+func (a *API) replyToComment(w http.ResponseWriter, r *http.Request) {
+	uid, err := callerID(r, "comment")
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+	parentID, err := idParam(r)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+
+	var req addCommentRequest
+	if err := decodeBody(r, &req); err != nil {
+		a.writeError(w, err)
+		return
+	}
+
+	c, err := ReplyToComment(a.db.WithContext(r.Context()), parentID, uid, req.Content)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, c)
+}
+
+func (a *API) deleteComment(w http.ResponseWriter, r *http.Request) {
+	uid, err := callerID(r, "delete comments")
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+	id, err := idParam(r)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+
+	hard := false
+	if v := r.URL.Query().Get("hard"); v != "" {
+		if hard, err = strconv.ParseBool(v); err != nil {
+			a.writeError(w, fmt.Errorf("%w: hard must be a boolean", ErrInvalidInput))
+			return
+		}
+	}
+
+	db := a.db.WithContext(r.Context())
+	if err := requireCommentOwner(db, id, uid); err != nil {
+		a.writeError(w, err)
+		return
+	}
+	if hard {
+		err = HardDeleteComment(db, id)
+	} else {
+		err = SoftDeleteComment(db, id)
+	}
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// requireCommentOwner checks that userID wrote the comment or its post, or is a moderator.
+func requireCommentOwner(db *gorm.DB, commentID, userID uint) error {
+	// Unscoped, a soft deleted comment can still be hard deleted, and so can the comments of a deleted post
+	var c Comment
+	if err := db.Unscoped().Select("id", "post_id", "user_id").First(&c, commentID).Error; err != nil {
+		return err
+	}
+	var p Post
+	if err := db.Unscoped().Select("id", "user_id").First(&p, c.PostID).Error; err != nil {
+		return err
+	}
+	if userID == c.UserID || userID == p.UserID {
+		return nil
+	}
+	if err := requireModerator(db, userID); err != nil {
+		if errors.Is(err, ErrForbidden) {
+			return fmt.Errorf("%w: only its author, the author of the post or a moderator can delete a comment", ErrForbidden)
+		}
+		return err
+	}
+	return nil
+}
+
+// callerID returns the caller, who must have identified themselves to do what.
+func callerID(r *http.Request, what string) (uint, error) {
+	uid, ok := userIDFrom(r.Context())
+	if !ok {
+		return 0, fmt.Errorf("%w: sign in to %s", ErrUnauthenticated, what)
+	}
+	return uid, nil
+}
+
+func (a *API) moderationQueue(w http.ResponseWriter, r *http.Request) {
+	uid, err := callerID(r, "moderate comments")
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+	limit, err := limitParam(r)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+
+	comments, err := ModerationQueue(a.db.WithContext(r.Context()), uid, limit)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, comments)
+}
+
+func (a *API) approveComment(w http.ResponseWriter, r *http.Request) {
+	uid, err := callerID(r, "moderate comments")
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+	id, err := idParam(r)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+
+	if err := ApproveComment(a.db.WithContext(r.Context()), id, uid); err != nil {
+		a.writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *API) rejectComment(w http.ResponseWriter, r *http.Request) {
+	uid, err := callerID(r, "moderate comments")
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+	id, err := idParam(r)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+
+	var req rejectCommentRequest
+	if err := decodeBody(r, &req); err != nil {
+		a.writeError(w, err)
+		return
+	}
+
+	if err := RejectComment(a.db.WithContext(r.Context()), id, uid, req.Reason); err != nil {
+		a.writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *API) reactionSummary(target ReactionTarget) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := idParam(r)
+		if err != nil {
+			a.writeError(w, err)
+			return
+		}
+
+		summary, err := ReactionSummary(a.db.WithContext(r.Context()), target, id)
+		if err != nil {
+			a.writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, summary)
+	}
+}
+
+func (a *API) react(target ReactionTarget) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		uid, err := callerID(r, "react")
+		if err != nil {
+			a.writeError(w, err)
+			return
+		}
+		id, err := idParam(r)
+		if err != nil {
+			a.writeError(w, err)
+			return
+		}
+
+		var req reactRequest
+		if err := decodeBody(r, &req); err != nil {
+			a.writeError(w, err)
+			return
+		}
+
+		if err := React(a.db.WithContext(r.Context()), uid, target, id, req.Kind); err != nil {
+			a.writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (a *API) unreact(target ReactionTarget) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		uid, err := callerID(r, "react")
+		if err != nil {
+			a.writeError(w, err)
+			return
+		}
+		id, err := idParam(r)
+		if err != nil {
+			a.writeError(w, err)
+			return
+		}
+
+		if err := Unreact(a.db.WithContext(r.Context()), uid, target, id); err != nil {
+			a.writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (a *API) tagCloud(w http.ResponseWriter, r *http.Request) {
+	limit := 0
+	if r.URL.Query().Has("limit") {
+		var err error
+		if limit, err = limitParam(r); err != nil {
+			a.writeError(w, err)
+			return
+		}
+	}
+
+	cloud, err := TagCloud(a.db.WithContext(r.Context()), limit)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, cloud)
+}
+
+func (a *API) tagPosts(w http.ResponseWriter, r *http.Request) {
+	limit, err := limitParam(r)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+
+	asHTML, err := htmlFormat(r)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+
+	posts, err := GetTagPosts(a.db.WithContext(r.Context()), r.PathValue("slug"), limit)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+	if asHTML {
+		withHTML(posts)
+	}
+	writeJSON(w, http.StatusOK, posts)
+}
+
+func (a *API) renameTag(w http.ResponseWriter, r *http.Request) {
+	id, err := idParam(r)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+	db := a.db.WithContext(r.Context())
+	if err := requireTagAdmin(db, r); err != nil {
+		a.writeError(w, err)
+		return
+	}
+
+	var req renameTagRequest
+	if err := decodeBody(r, &req); err != nil {
+		a.writeError(w, err)
+		return
+	}
+
+	tag, err := RenameTag(db, id, req.Name)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, tag)
+}
+
+func (a *API) mergeTag(w http.ResponseWriter, r *http.Request) {
+	id, err := idParam(r)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+	db := a.db.WithContext(r.Context())
+	if err := requireTagAdmin(db, r); err != nil {
+		a.writeError(w, err)
+		return
+	}
+
+	var req mergeTagRequest
+	if err := decodeBody(r, &req); err != nil {
+		a.writeError(w, err)
+		return
+	}
+
+	moved, err := MergeTags(db, id, req.IntoID)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]int64{"Moved": moved})
+}
+
+// requireTagAdmin checks that the caller is a moderator, tags are shared by every post.
+func requireTagAdmin(db *gorm.DB, r *http.Request) error {
+	uid, err := callerID(r, "manage tags")
+	if err != nil {
+		return err
+	}
+	return requireModerator(db, uid)
+}
+
+func (a *API) userLatestPosts(w http.ResponseWriter, r *http.Request) {
+	userID, err := idParam(r)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+	limit, err := limitParam(r)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+	asHTML, err := htmlFormat(r)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+
+	db := a.db.WithContext(r.Context())
+	if err := db.First(&User{}, userID).Error; err != nil {
+		a.writeError(w, err)
+		return
+	}
+
+	page, err := GetUserLatestPostsPage(db, userID, r.URL.Query().Get("cursor"), limit)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+	if asHTML {
+		withHTML(page.Items)
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+func (a *API) followers(w http.ResponseWriter, r *http.Request) {
+	a.listFollows(w, r, Followers)
+}
+
+func (a *API) following(w http.ResponseWriter, r *http.Request) {
+	a.listFollows(w, r, Following)
+}
+
+func (a *API) listFollows(w http.ResponseWriter, r *http.Request, list func(db *gorm.DB, userID uint) ([]User, error)) {
+	userID, err := idParam(r)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+
+	db := a.db.WithContext(r.Context())
+	if err := db.First(&User{}, userID).Error; err != nil {
+		a.writeError(w, err)
+		return
+	}
+
+	users, err := list(db, userID)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, users)
+}
+
+func (a *API) follow(w http.ResponseWriter, r *http.Request) {
+	uid, err := callerID(r, "follow")
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+	followeeID, err := idParam(r)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+
+	if err := FollowUser(a.db.WithContext(r.Context()), uid, followeeID); err != nil {
+		a.writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *API) unfollow(w http.ResponseWriter, r *http.Request) {
+	uid, err := callerID(r, "follow")
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+	followeeID, err := idParam(r)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+
+	if err := UnfollowUser(a.db.WithContext(r.Context()), uid, followeeID); err != nil {
+		a.writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *API) homeFeed(w http.ResponseWriter, r *http.Request) {
+	uid, err := callerID(r, "read the home feed")
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+	limit, err := limitParam(r)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+
+	asHTML, err := htmlFormat(r)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+
+	feed := HomeFeed
+	if v := r.URL.Query().Get("materialized"); v != "" {
+		materialized, err := strconv.ParseBool(v)
+		if err != nil {
+			a.writeError(w, fmt.Errorf("%w: materialized must be a boolean", ErrInvalidInput))
+			return
+		}
+		if materialized {
+			feed = MaterializedHomeFeed
+		}
+	}
+
+	page, err := feed(a.db.WithContext(r.Context()), uid, r.URL.Query().Get("cursor"), limit)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+	if asHTML {
+		withHTML(page.Items)
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+type feedFormat int
+
+const (
+	feedRSS feedFormat = iota
+	feedAtom
+)
+
+func (a *API) siteSyndication(r *http.Request, limit int) (*Syndication, error) {
+	return SiteSyndication(a.db.WithContext(r.Context()), limit)
+}
+
+func (a *API) userSyndication(r *http.Request, limit int) (*Syndication, error) {
+	userID, err := idParam(r)
+	if err != nil {
+		return nil, err
+	}
+	return UserSyndication(a.db.WithContext(r.Context()), userID, limit)
+}
+
+func (a *API) tagSyndication(r *http.Request, limit int) (*Syndication, error) {
+	return TagSyndication(a.db.WithContext(r.Context()), r.PathValue("slug"), limit)
+}
+
+// syndicate serves the feed loaded by load as RSS or Atom, with conditional GET.
+func (a *API) syndicate(format feedFormat, load func(r *http.Request, limit int) (*Syndication, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		limit, err := limitParam(r)
+		if err != nil {
+			a.writeError(w, err)
+			return
+		}
+
+		s, err := load(r, limit)
+		if err != nil {
+			a.writeError(w, err)
+			return
+		}
+
+		scheme := "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+		baseURL := scheme + "://" + r.Host
+		self := baseURL + r.URL.RequestURI()
+
+		var body []byte
+		if format == feedAtom {
+			body, err = s.Atom(baseURL, self)
+			w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+		} else {
+			body, err = s.RSS(baseURL, self)
+			w.Header().Set("Content-Type", "application/rss+xml; charset=utf-8")
+		}
+		if err != nil {
+			a.writeError(w, err)
+			return
+		}
+
+		// the feed is rebuilt on every request, the validators only save the download
+		sum := sha256.Sum256(body)
+		w.Header().Set("ETag", fmt.Sprintf(`"%x"`, sum[:16]))
+		// ServeContent answers If-None-Match and If-Modified-Since, and skips Last-Modified for the epoch of empty feeds
+		http.ServeContent(w, r, "", s.Updated, bytes.NewReader(body))
+	}
+}
+
+func idParam(r *http.Request) (uint, error) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil || id == 0 {
+		return 0, fmt.Errorf("%w: invalid id %q", ErrInvalidInput, r.PathValue("id"))
+	}
+	return uint(id), nil
+}
+
+// uintQuery reads an optional unsigned query parameter, 0 when absent.
+func uintQuery(r *http.Request, name string) (int, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return 0, nil
+	}
+
+	n, err := strconv.ParseUint(v, 10, 31)
+	if err != nil {
+		return 0, fmt.Errorf("%w: invalid %s %q", ErrInvalidInput, name, v)
+	}
+	return int(n), nil
+}
+
+// htmlFormat reads the format query parameter of the post read paths, true for ?format=html.
+func htmlFormat(r *http.Request) (bool, error) {
+	switch v := r.URL.Query().Get("format"); v {
+	case "", "markdown":
+		return false, nil
+	case "html":
+		return true, nil
+	default:
+		return false, fmt.Errorf("%w: format must be markdown or html, not %q", ErrInvalidInput, v)
+	}
+}
+
+// withHTML replaces the Markdown Content of posts with its cached HTML.
+func withHTML(posts []Post) {
+	for i := range posts {
+		posts[i].Content = posts[i].ContentHTML
+	}
+}
+
+// windowParams reads the from and to query parameters (RFC 3339), by default the last 7 days.
+func (a *API) windowParams(r *http.Request) (from, to time.Time, err error) {
+	query := r.URL.Query()
+	to = a.db.NowFunc()
+	if v := query.Get("to"); v != "" {
+		if to, err = time.Parse(time.RFC3339, v); err != nil {
+			return from, to, fmt.Errorf("%w: invalid to %q", ErrInvalidInput, v)
+		}
+	}
+	from = to.AddDate(0, 0, -7)
+	if v := query.Get("from"); v != "" {
+		if from, err = time.Parse(time.RFC3339, v); err != nil {
+			return from, to, fmt.Errorf("%w: invalid from %q", ErrInvalidInput, v)
+		}
+	}
+	if !from.Before(to) {
+		return from, to, fmt.Errorf("%w: from must be before to", ErrInvalidInput)
+	}
+	return from, to, nil
+}
+
+func limitParam(r *http.Request) (int, error) {
+	v := r.URL.Query().Get("limit")
+	if v == "" {
+		return defaultLimit, nil
+	}
+
+	limit, err := strconv.Atoi(v)
+	if err != nil || limit <= 0 || limit > maxLimit {
+		return 0, fmt.Errorf("%w: limit must be between 1 and %d", ErrInvalidInput, maxLimit)
+	}
+	return limit, nil
+}
+
+func decodeBody(r *http.Request, v any) error {
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidInput, err)
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// statusFor maps domain and GORM errors to HTTP status codes.
+func statusFor(err error) int {
+	switch {
+	case errors.Is(err, ErrInvalidInput), errors.Is(err, pagination.ErrInvalidCursor):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrUnauthenticated):
+		return http.StatusUnauthorized
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrForbidden):
+		return http.StatusForbidden
+	case errors.Is(err, gorm.ErrDuplicatedKey), errors.Is(err, ErrInvalidTransition), errors.Is(err, ErrAlreadyModerated):
+		return http.StatusConflict
+	case errors.Is(err, ErrSearchUnavailable):
+		return http.StatusNotImplemented
+	case errors.As(err, new(*RateLimitError)):
+		return http.StatusTooManyRequests
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func (a *API) writeError(w http.ResponseWriter, err error) {
+	// constraint errors are only translated by GORM when the DB is opened with TranslateError
+	if t, ok := a.db.Dialector.(gorm.ErrorTranslator); ok {
+		err = t.Translate(err)
+	}
+
+	var rl *RateLimitError
+	if errors.As(err, &rl) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(rl.RetryAfter.Seconds()))))
+	}
+
+	status := statusFor(err)
+	msg := err.Error()
+	if status == http.StatusInternalServerError {
+		// don't leak SQL details to clients
+		msg = http.StatusText(status)
+	}
+	writeJSON(w, status, map[string]string{"error": msg})
+}
@@ -0,0 +1,217 @@
+package project
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// newTestServer serves NewAPI over a fresh in-memory database seeded with users and the tags,
+// the first user moderates.
+func newTestServer(t *testing.T, cfg Config, users int) (*httptest.Server, *SeedResult) {
+	t.Helper()
+	db := openTestDB(t, cfg)
+	seeded, err := Seed(db, SeedOptions{Seed: 1, Users: users})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	api := NewAPI(db)
+	api.Authenticate = HeaderAuth
+	api.Config = cfg
+	srv := httptest.NewServer(api)
+	t.Cleanup(srv.Close)
+	return srv, seeded
+}
+
+// call sends a request on behalf of userID (anonymous for 0), decodes the response into out when given
+// and returns the status code.
+func call(t *testing.T, srv *httptest.Server, method, path string, userID uint, body, out any) int {
+	t.Helper()
+	var r bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&r).Encode(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	req, err := http.NewRequest(method, srv.URL+path, &r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if userID != 0 {
+		req.Header.Set("X-User-ID", strconv.FormatUint(uint64(userID), 10))
+	}
+
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if out != nil && resp.StatusCode < 300 {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func TestAPIErrorStatus(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.CommentRateLimit = RateLimit{Limit: 1, Window: time.Hour}
+	srv, seeded := newTestServer(t, cfg, 3)
+	moderator, author, reader := seeded.UserIDs[0], seeded.UserIDs[1], seeded.UserIDs[2]
+
+	var published, draft Post
+	if code := call(t, srv, "POST", "/posts/publish", author, publishPostRequest{Subject: "Hello", Content: "World"}, &published); code != http.StatusCreated {
+		t.Fatalf("publish: got %d", code)
+	}
+	if code := call(t, srv, "POST", "/posts", author, createPostRequest{Subject: "Draft", Content: "Later"}, &draft); code != http.StatusCreated {
+		t.Fatalf("create: got %d", code)
+	}
+	var comment Comment
+	if code := call(t, srv, "POST", fmt.Sprintf("/posts/%d/comments", published.ID), moderator, addCommentRequest{Content: "First"}, &comment); code != http.StatusCreated {
+		t.Fatalf("comment: got %d", code)
+	}
+
+	searchStatus := http.StatusNotImplemented
+	if searchAvailable(openTestDB(t, cfg)) {
+		searchStatus = http.StatusOK // built with -tags sqlite_fts5
+	}
+
+	// the rows share the server and run in order
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		userID uint
+		body   any
+		want   int
+	}{
+		{"anonymous write", "POST", "/posts", 0, createPostRequest{Subject: "Hi", Content: "There"}, http.StatusUnauthorized},
+		{"author in the body", "POST", "/posts", author, map[string]any{"Subject": "Hi", "Content": "There", "UserID": reader}, http.StatusBadRequest},
+		{"missing subject", "POST", "/posts", author, createPostRequest{Content: "There"}, http.StatusBadRequest},
+		{"invalid id", "GET", "/posts/abc", 0, nil, http.StatusBadRequest},
+		{"missing post", "GET", "/posts/999", 0, nil, http.StatusNotFound},
+		{"draft of someone else", "GET", fmt.Sprintf("/posts/%d", draft.ID), reader, nil, http.StatusNotFound},
+		{"draft of the caller", "GET", fmt.Sprintf("/posts/%d", draft.ID), author, nil, http.StatusOK},
+		{"comment under a draft", "POST", fmt.Sprintf("/posts/%d/comments", draft.ID), reader, addCommentRequest{Content: "Early"}, http.StatusNotFound},
+		{"archive a draft", "POST", fmt.Sprintf("/posts/%d/archive", draft.ID), author, nil, http.StatusConflict},
+		{"edit someone else's post", "PATCH", fmt.Sprintf("/posts/%d", published.ID), reader, updatePostRequest{Subject: "Mine", Content: "Now"}, http.StatusForbidden},
+		{"delete someone else's comment", "DELETE", fmt.Sprintf("/comments/%d", comment.ID), reader, nil, http.StatusForbidden},
+		{"moderation queue as a reader", "GET", "/comments/pending", reader, nil, http.StatusForbidden},
+		{"moderate one's own comment", "POST", fmt.Sprintf("/comments/%d/reject", comment.ID), moderator, rejectCommentRequest{Reason: "oops"}, http.StatusForbidden},
+		{"search", "GET", "/posts/search?q=hello", 0, nil, searchStatus},
+		{"first comment of the hour", "POST", fmt.Sprintf("/posts/%d/comments", published.ID), reader, addCommentRequest{Content: "Nice"}, http.StatusCreated},
+		{"second comment of the hour", "POST", fmt.Sprintf("/posts/%d/comments", published.ID), reader, addCommentRequest{Content: "Really"}, http.StatusTooManyRequests},
+	}
+	for _, tt := range tests {
+		if got := call(t, srv, tt.method, tt.path, tt.userID, tt.body, nil); got != tt.want {
+			t.Errorf("%s: %s %s got %d, want %d", tt.name, tt.method, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestAPIPostFlow(t *testing.T) {
+	srv, seeded := newTestServer(t, DefaultConfig(), 3)
+	moderator, author, reader := seeded.UserIDs[0], seeded.UserIDs[1], seeded.UserIDs[2]
+
+	var p Post
+	if code := call(t, srv, "POST", "/posts", author, createPostRequest{Subject: "Flow", Content: "From draft to comments"}, &p); code != http.StatusCreated {
+		t.Fatalf("create: got %d", code)
+	}
+	if p.UserID != author || p.Status != PostDraft {
+		t.Fatalf("create: got user %d status %s, want user %d status %s", p.UserID, p.Status, author, PostDraft)
+	}
+	if code := call(t, srv, "GET", fmt.Sprintf("/posts/%d", p.ID), 0, nil, nil); code != http.StatusNotFound {
+		t.Fatalf("draft read anonymously: got %d, want %d", code, http.StatusNotFound)
+	}
+
+	if code := call(t, srv, "POST", fmt.Sprintf("/posts/%d/publish", p.ID), author, nil, &p); code != http.StatusOK {
+		t.Fatalf("publish: got %d", code)
+	}
+	if p.Status != PostPublished || p.PublishedAt == nil {
+		t.Fatalf("publish: got status %s, published at %v", p.Status, p.PublishedAt)
+	}
+
+	comments := fmt.Sprintf("/posts/%d/comments", p.ID)
+	var soft, hard, reply Comment
+	if code := call(t, srv, "POST", comments, reader, addCommentRequest{Content: "Soft deleted soon"}, &soft); code != http.StatusCreated {
+		t.Fatalf("comment: got %d", code)
+	}
+	if code := call(t, srv, "POST", comments, reader, addCommentRequest{Content: "Hard deleted soon"}, &hard); code != http.StatusCreated {
+		t.Fatalf("comment: got %d", code)
+	}
+	if code := call(t, srv, "POST", fmt.Sprintf("/comments/%d/replies", hard.ID), author, addCommentRequest{Content: "Gone with its parent"}, &reply); code != http.StatusCreated {
+		t.Fatalf("reply: got %d", code)
+	}
+	if soft.UserID != reader || reply.ParentID == nil || *reply.ParentID != hard.ID {
+		t.Fatalf("comments: got %+v and %+v", soft, reply)
+	}
+
+	thread := func() []ThreadComment {
+		t.Helper()
+		var tc []ThreadComment
+		if code := call(t, srv, "GET", comments, 0, nil, &tc); code != http.StatusOK {
+			t.Fatalf("thread: got %d", code)
+		}
+		return tc
+	}
+	if got := len(thread()); got != 3 {
+		t.Fatalf("thread: got %d comments, want 3", got)
+	}
+
+	// the comment author soft deletes, the post author hard deletes with the replies
+	if code := call(t, srv, "DELETE", fmt.Sprintf("/comments/%d", soft.ID), reader, nil, nil); code != http.StatusNoContent {
+		t.Fatalf("soft delete: got %d", code)
+	}
+	if code := call(t, srv, "DELETE", fmt.Sprintf("/comments/%d?hard=true", hard.ID), author, nil, nil); code != http.StatusNoContent {
+		t.Fatalf("hard delete: got %d", code)
+	}
+	if got := thread(); len(got) != 0 {
+		t.Fatalf("thread after deletes: got %+v, want none", got)
+	}
+
+	// the soft deleted comment can still be purged, by a moderator, the hard deleted ones are gone
+	if code := call(t, srv, "DELETE", fmt.Sprintf("/comments/%d?hard=true", soft.ID), moderator, nil, nil); code != http.StatusNoContent {
+		t.Fatalf("hard delete of a soft deleted comment: got %d", code)
+	}
+	for _, id := range []uint{hard.ID, reply.ID} {
+		if code := call(t, srv, "DELETE", fmt.Sprintf("/comments/%d", id), moderator, nil, nil); code != http.StatusNotFound {
+			t.Errorf("delete of hard deleted comment %d: got %d, want %d", id, code, http.StatusNotFound)
+		}
+	}
+}
+
+func TestAPITagAdmin(t *testing.T) {
+	srv, seeded := newTestServer(t, DefaultConfig(), 2)
+	moderator, reader := seeded.UserIDs[0], seeded.UserIDs[1]
+	tag, into := seeded.TagIDs[0], seeded.TagIDs[1]
+
+	rename := fmt.Sprintf("/tags/%d", tag)
+	merge := fmt.Sprintf("/tags/%d/merge", tag)
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		userID uint
+		body   any
+		want   int
+	}{
+		{"anonymous rename", "PATCH", rename, 0, renameTagRequest{Name: "Golang"}, http.StatusUnauthorized},
+		{"anonymous merge", "POST", merge, 0, mergeTagRequest{IntoID: into}, http.StatusUnauthorized},
+		{"reader rename", "PATCH", rename, reader, renameTagRequest{Name: "Golang"}, http.StatusForbidden},
+		{"reader merge", "POST", merge, reader, mergeTagRequest{IntoID: into}, http.StatusForbidden},
+		{"moderator rename", "PATCH", rename, moderator, renameTagRequest{Name: "Golang"}, http.StatusOK},
+		{"moderator merge", "POST", merge, moderator, mergeTagRequest{IntoID: into}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		if got := call(t, srv, tt.method, tt.path, tt.userID, tt.body, nil); got != tt.want {
+			t.Errorf("%s: %s %s got %d, want %d", tt.name, tt.method, tt.path, got, tt.want)
+		}
+	}
+}
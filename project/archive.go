@@ -0,0 +1,377 @@
+// Blog archive export/import
+//
+// An archive is NDJSON, one record per line, in dependency order so every reference points to a record
+// that came before it:
+//
+// {"Type":"archive","Data":{"Version":1,"ExportedAt":"..."}}
+// {"Type":"user","Data":{"ID":1,"Name":"user_1",...}}
+// {"Type":"tag","Data":{"ID":1,"Name":"Go","Slug":"go",...}}
+// {"Type":"post","Data":{"ID":1,"UserID":1,...}}
+// {"Type":"post_tag","Data":{"PostID":1,"TagID":1}}
+// {"Type":"comment","Data":{"ID":1,"PostID":1,"ParentID":null,...}}
+//
+// Soft deleted posts and comments, and comments of any moderation status, are exported too.
+// Primary keys are those of the source database, the importer gives every row a new one and remaps
+// the foreign keys (UserID, PostID, ParentID, ModeratedBy, post_tags) through the old -> new ID maps.
+// The whole import runs in one transaction, a dangling reference rolls everything back.
+//
+// Reactions, follows and feeds are not part of the archive, imported posts start with a ReactionCount of 0.
+// Revisions aren't either, the Post hook gives every imported post its revision 1.
+
+package project
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+const archiveVersion = 1
+
+var ErrInvalidArchive = fmt.Errorf("%w: invalid archive", ErrInvalidInput)
+
+type archiveHeader struct {
+	Version    int
+	ExportedAt time.Time
+}
+
+type archiveRecord struct {
+	Type string
+	Data json.RawMessage
+}
+
+// ArchiveStats counts the records of an archive by type.
+type ArchiveStats struct {
+	Users    int
+	Tags     int
+	Posts    int
+	PostTags int
+	Comments int
+}
+
+const exportBatchSize = 500
+
+// ExportBlog streams the whole blog to w as NDJSON, see the archive format above.
+func ExportBlog(db *gorm.DB, w io.Writer) (*ArchiveStats, error) {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	stats := &ArchiveStats{}
+
+	write := func(typ string, v any) error {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		return enc.Encode(archiveRecord{Type: typ, Data: data})
+	}
+
+	if err := write("archive", archiveHeader{Version: archiveVersion, ExportedAt: db.NowFunc()}); err != nil {
+		return nil, err
+	}
+
+	// one read transaction, so the archive is a consistent snapshot
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var users []User
+		if err := tx.FindInBatches(&users, exportBatchSize, func(_ *gorm.DB, _ int) error {
+			for _, u := range users {
+				if err := write("user", u); err != nil {
+					return err
+				}
+			}
+			stats.Users += len(users)
+			return nil
+		}).Error; err != nil {
+			return err
+		}
+
+		var tags []Tag
+		if err := tx.FindInBatches(&tags, exportBatchSize, func(_ *gorm.DB, _ int) error {
+			for _, t := range tags {
+				if err := write("tag", t); err != nil {
+					return err
+				}
+			}
+			stats.Tags += len(tags)
+			return nil
+		}).Error; err != nil {
+			return err
+		}
+
+		var posts []Post
+		if err := tx.Unscoped().FindInBatches(&posts, exportBatchSize, func(_ *gorm.DB, _ int) error {
+			for _, p := range posts {
+				if err := write("post", p); err != nil {
+					return err
+				}
+			}
+			stats.Posts += len(posts)
+			return nil
+		}).Error; err != nil {
+			return err
+		}
+
+		// post_tags has a composite key, FindInBatches needs a single one
+		rows, err := tx.Model(&postTag{}).Order("post_id, tag_id").Rows()
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var l postTag
+			if err := tx.ScanRows(rows, &l); err != nil {
+				return err
+			}
+			if err := write("post_tag", l); err != nil {
+				return err
+			}
+			stats.PostTags++
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		// a reply is always created after its parent, so ID order puts parents first
+		// Unscoped also lifts the moderation filter
+		var comments []Comment
+		if err := tx.Unscoped().FindInBatches(&comments, exportBatchSize, func(_ *gorm.DB, _ int) error {
+			for _, c := range comments {
+				if err := write("comment", c); err != nil {
+					return err
+				}
+			}
+			stats.Comments += len(comments)
+			return nil
+		}).Error; err != nil {
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return stats, bw.Flush()
+}
+
+// archiveImport holds the old -> new primary key maps of a running import.
+type archiveImport struct {
+	tx       *gorm.DB
+	users    map[uint]uint
+	tags     map[uint]uint
+	posts    map[uint]uint
+	comments map[uint]uint
+	stats    ArchiveStats
+}
+
+func remap(ids map[uint]uint, id uint, what string) (uint, error) {
+	newID, ok := ids[id]
+	if !ok {
+		return 0, fmt.Errorf("%w: unknown %s %d", ErrInvalidArchive, what, id)
+	}
+	return newID, nil
+}
+
+func (im *archiveImport) user(data json.RawMessage) error {
+	var u User
+	if err := json.Unmarshal(data, &u); err != nil {
+		return err
+	}
+	oldID := u.ID
+	u.ID, u.Posts = 0, nil
+	if err := im.tx.Create(&u).Error; err != nil {
+		return err
+	}
+	im.users[oldID] = u.ID
+	im.stats.Users++
+	return nil
+}
+
+func (im *archiveImport) tag(data json.RawMessage) error {
+	var t Tag
+	if err := json.Unmarshal(data, &t); err != nil {
+		return err
+	}
+	oldID := t.ID
+	t.ID, t.Posts = 0, nil
+	if err := im.tx.Create(&t).Error; err != nil {
+		return err
+	}
+	im.tags[oldID] = t.ID
+	im.stats.Tags++
+	return nil
+}
+
+func (im *archiveImport) post(data json.RawMessage) error {
+	var p Post
+	if err := json.Unmarshal(data, &p); err != nil {
+		return err
+	}
+	userID, err := remap(im.users, p.UserID, "user")
+	if err != nil {
+		return err
+	}
+
+	oldID := p.ID
+	p.ID, p.UserID, p.Tags, p.Comments = 0, userID, nil, nil
+	p.ReactionCount = 0 // reactions aren't archived
+	if err := im.tx.Create(&p).Error; err != nil {
+		return err
+	}
+	im.posts[oldID] = p.ID
+	im.stats.Posts++
+	return nil
+}
+
+func (im *archiveImport) postTag(data json.RawMessage) error {
+	var l postTag
+	if err := json.Unmarshal(data, &l); err != nil {
+		return err
+	}
+	postID, err := remap(im.posts, l.PostID, "post")
+	if err != nil {
+		return err
+	}
+	tagID, err := remap(im.tags, l.TagID, "tag")
+	if err != nil {
+		return err
+	}
+
+	if err := im.tx.Create(&postTag{PostID: postID, TagID: tagID}).Error; err != nil {
+		return err
+	}
+	im.stats.PostTags++
+	return nil
+}
+
+func (im *archiveImport) comment(data json.RawMessage) error {
+	var c Comment
+	if err := json.Unmarshal(data, &c); err != nil {
+		return err
+	}
+
+	postID, err := remap(im.posts, c.PostID, "post")
+	if err != nil {
+		return err
+	}
+	userID, err := remap(im.users, c.UserID, "user")
+	if err != nil {
+		return err
+	}
+	if c.ParentID != nil {
+		parentID, err := remap(im.comments, *c.ParentID, "parent comment")
+		if err != nil {
+			return err
+		}
+		c.ParentID = &parentID
+	}
+	if c.ModeratedBy != nil {
+		moderatorID, err := remap(im.users, *c.ModeratedBy, "moderator")
+		if err != nil {
+			return err
+		}
+		c.ModeratedBy = &moderatorID
+	}
+	if c.Status == "" {
+		c.Status = CommentApproved // archived comments were already moderated, skip the filters
+	}
+
+	oldID := c.ID
+	c.ID, c.PostID, c.UserID = 0, postID, userID
+	if err := im.tx.Create(&c).Error; err != nil {
+		return err
+	}
+	im.comments[oldID] = c.ID
+	im.stats.Comments++
+	return nil
+}
+
+// ImportBlog reads an archive written by ExportBlog and inserts it with new primary keys, in one transaction.
+// Users and tags must not clash with existing emails and tag names.
+func ImportBlog(db *gorm.DB, r io.Reader) (*ArchiveStats, error) {
+	var stats ArchiveStats
+	err := db.Transaction(func(tx *gorm.DB) error {
+		im := &archiveImport{
+			tx:       tx,
+			users:    make(map[uint]uint),
+			tags:     make(map[uint]uint),
+			posts:    make(map[uint]uint),
+			comments: make(map[uint]uint),
+		}
+
+		dec := json.NewDecoder(r)
+		for line := 1; ; line++ {
+			var rec archiveRecord
+			if err := dec.Decode(&rec); err == io.EOF {
+				break
+			} else if err != nil {
+				return fmt.Errorf("%w: record %d: %v", ErrInvalidArchive, line, err) // roll back
+			}
+
+			var err error
+			switch rec.Type {
+			case "archive":
+				var h archiveHeader
+				if err = json.Unmarshal(rec.Data, &h); err == nil && h.Version != archiveVersion {
+					err = fmt.Errorf("%w: unsupported version %d", ErrInvalidArchive, h.Version)
+				}
+			case "user":
+				err = im.user(rec.Data)
+			case "tag":
+				err = im.tag(rec.Data)
+			case "post":
+				err = im.post(rec.Data)
+			case "post_tag":
+				err = im.postTag(rec.Data)
+			case "comment":
+				err = im.comment(rec.Data)
+			default:
+				err = fmt.Errorf("%w: unknown record type %q", ErrInvalidArchive, rec.Type)
+			}
+			if err != nil {
+				return fmt.Errorf("record %d: %w", line, err) // roll back
+			}
+		}
+
+		stats = im.stats
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+func ArchiveTest(db *gorm.DB) {
+	var archive bytes.Buffer
+	stats, err := ExportBlog(db, &archive)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("exported %+v, %d bytes\n", *stats, archive.Len())
+
+	fresh, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{Logger: db.Logger, NowFunc: db.NowFunc})
+	if err != nil {
+		panic(err)
+	}
+	// a single connection, every new connection would open another empty in-memory database
+	sqlDB, err := fresh.DB()
+	if err != nil {
+		panic(err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	defer sqlDB.Close()
+
+	if err := Migrate(fresh); err != nil {
+		panic(err)
+	}
+	stats, err = ImportBlog(fresh, &archive)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("imported %+v into a fresh database\n", *stats)
+}
@@ -0,0 +1,282 @@
+package project
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"maps"
+	"strings"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+// blogGraph renders the Preload("Posts.Tags") graph of every user without primary and foreign keys,
+// which differ between the two sides of a round trip.
+func blogGraph(t *testing.T, db *gorm.DB) string {
+	t.Helper()
+	var users []User
+	if err := db.Preload("Posts", func(db *gorm.DB) *gorm.DB {
+		return db.Order("created_at, subject")
+	}).Preload("Posts.Tags", func(db *gorm.DB) *gorm.DB {
+		return db.Order("name")
+	}).Order("email").Find(&users).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	for i := range users {
+		users[i].ID = 0
+		for j := range users[i].Posts {
+			p := &users[i].Posts[j]
+			p.ID, p.UserID, p.ReactionCount = 0, 0, 0
+			for k := range p.Tags {
+				p.Tags[k].ID = 0
+			}
+		}
+	}
+	b, err := json.Marshal(users)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+// archiveSource returns a seeded blog with a moderated reply, a deleted post and its archive.
+func archiveSource(t *testing.T) (*gorm.DB, *bytes.Buffer, *ArchiveStats) {
+	t.Helper()
+	db := openTestDB(t, DefaultConfig())
+	seeded, err := Seed(db, SeedOptions{Seed: 1, Users: 4, Posts: 8, Comments: 6, TagsPerPost: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	moderator := seeded.UserIDs[0]
+
+	reply, err := ReplyToComment(db, seeded.CommentIDs[0], seeded.UserIDs[1], "A reply")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReplyToComment(db, reply.ID, seeded.UserIDs[2], "A reply to the reply"); err != nil {
+		t.Fatal(err)
+	}
+	if err := RejectComment(db, reply.ID, moderator, "off topic"); err != nil {
+		t.Fatal(err)
+	}
+	if err := DeletePost(db, seeded.PostIDs[1]); err != nil {
+		t.Fatal(err)
+	}
+
+	var archive bytes.Buffer
+	stats, err := ExportBlog(db, &archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db, &archive, stats
+}
+
+// archiveTarget returns a database that already holds a user, a tag, a post and a comment,
+// so the imported rows can't keep the primary keys of the archive.
+func archiveTarget(t *testing.T) *gorm.DB {
+	t.Helper()
+	db := openTestDB(t, DefaultConfig())
+	u, err := CreateUser(db, "existing", "existing@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tag := Tag{Name: "existing"}
+	if err := db.Create(&tag).Error; err != nil {
+		t.Fatal(err)
+	}
+	p, err := PublishPostWithTags(db, u.ID, "Existing", "Already there", []uint{tag.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := AddComment(db, p.ID, u.ID, "Existing comment"); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// rowCounts counts the rows of every archived table, deleted ones included.
+func rowCounts(t *testing.T, db *gorm.DB) map[string]int64 {
+	t.Helper()
+	counts := make(map[string]int64)
+	for table, model := range map[string]any{
+		"users": &User{}, "tags": &Tag{}, "posts": &Post{}, "post_tags": &postTag{}, "comments": &Comment{}, "post_revisions": &PostRevision{},
+	} {
+		var n int64
+		if err := db.Unscoped().Model(model).Count(&n).Error; err != nil {
+			t.Fatal(err)
+		}
+		counts[table] = n
+	}
+	return counts
+}
+
+func TestArchiveRoundTrip(t *testing.T) {
+	src, archive, exported := archiveSource(t)
+	dst := archiveTarget(t)
+	before := rowCounts(t, dst)
+
+	imported, err := ImportBlog(dst, archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *imported != *exported {
+		t.Fatalf("imported %+v, exported %+v", *imported, *exported)
+	}
+	after := rowCounts(t, dst)
+	if after["comments"]-before["comments"] != int64(exported.Comments) || after["posts"]-before["posts"] != int64(exported.Posts) {
+		t.Fatalf("%v rows before the import, %v after, want %+v more", before, after, *exported)
+	}
+
+	// the rows of the archive, in ID order on both sides
+	var srcUsers, dstUsers []User
+	var srcPosts, dstPosts []Post
+	var srcComments, dstComments []Comment
+	src.Unscoped().Order("id").Find(&srcUsers)
+	src.Unscoped().Order("id").Find(&srcPosts)
+	src.Unscoped().Order("id").Find(&srcComments)
+	dst.Unscoped().Where("email <> ?", "existing@example.com").Order("id").Find(&dstUsers)
+	dst.Unscoped().Where("subject <> ?", "Existing").Order("id").Find(&dstPosts)
+	dst.Unscoped().Where("content <> ?", "Existing comment").Order("id").Find(&dstComments)
+	if len(dstUsers) != len(srcUsers) || len(dstPosts) != len(srcPosts) || len(dstComments) != len(srcComments) {
+		t.Fatalf("imported %d users, %d posts, %d comments, want %d, %d, %d",
+			len(dstUsers), len(dstPosts), len(dstComments), len(srcUsers), len(srcPosts), len(srcComments))
+	}
+
+	// old -> new IDs, and every foreign key must follow them
+	users, posts, comments := make(map[uint]uint), make(map[uint]uint), make(map[uint]uint)
+	for i := range srcUsers {
+		users[srcUsers[i].ID] = dstUsers[i].ID
+	}
+	for i := range srcPosts {
+		posts[srcPosts[i].ID] = dstPosts[i].ID
+	}
+	for i := range srcComments {
+		comments[srcComments[i].ID] = dstComments[i].ID
+	}
+	if users[srcUsers[0].ID] == srcUsers[0].ID || comments[srcComments[0].ID] == srcComments[0].ID {
+		t.Fatal("the imported rows kept their primary keys, the remapping isn't exercised")
+	}
+
+	var replies, moderated int
+	for i, s := range srcComments {
+		d := dstComments[i]
+		if d.Content != s.Content || d.Status != s.Status || d.Depth != s.Depth || d.DeletedAt.Valid != s.DeletedAt.Valid {
+			t.Errorf("comment %d imported as %+v, want %+v", s.ID, d, s)
+		}
+		if d.PostID != posts[s.PostID] || d.UserID != users[s.UserID] {
+			t.Errorf("comment %d: post %d and user %d, want %d and %d", s.ID, d.PostID, d.UserID, posts[s.PostID], users[s.UserID])
+		}
+		switch {
+		case s.ParentID == nil:
+			if d.ParentID != nil {
+				t.Errorf("comment %d: parent %d, want none", s.ID, *d.ParentID)
+			}
+		case d.ParentID == nil || *d.ParentID != comments[*s.ParentID]:
+			t.Errorf("comment %d: parent %v, want %d", s.ID, d.ParentID, comments[*s.ParentID])
+		default:
+			replies++
+		}
+		switch {
+		case s.ModeratedBy == nil:
+			if d.ModeratedBy != nil {
+				t.Errorf("comment %d: moderated by %d, want nobody", s.ID, *d.ModeratedBy)
+			}
+		case d.ModeratedBy == nil || *d.ModeratedBy != users[*s.ModeratedBy]:
+			t.Errorf("comment %d: moderated by %v, want %d", s.ID, d.ModeratedBy, users[*s.ModeratedBy])
+		default:
+			moderated++
+		}
+	}
+	if replies != 2 || moderated != 1 {
+		t.Fatalf("checked %d replies and %d moderated comments, want 2 and 1", replies, moderated)
+	}
+
+	// the posts, their tags and their authors, without the rows that were already there
+	if err := dst.Unscoped().Where("content = ?", "Existing comment").Delete(&Comment{}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := dst.Unscoped().Select("Tags").Where("subject = ?", "Existing").Delete(&Post{}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := dst.Where("name = ?", "existing").Delete(&Tag{}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := dst.Where("email = ?", "existing@example.com").Delete(&User{}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if got, want := blogGraph(t, dst), blogGraph(t, src); got != want {
+		t.Fatalf("imported graph\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestImportInvalidArchive(t *testing.T) {
+	_, archive, _ := archiveSource(t)
+	lines := strings.SplitAfter(archive.String(), "\n")
+
+	// drop the lines for which drop returns true
+	without := func(drop func(typ string, data map[string]any) bool) string {
+		var b strings.Builder
+		for _, line := range lines {
+			var rec struct {
+				Type string
+				Data map[string]any
+			}
+			if line != "" {
+				if err := json.Unmarshal([]byte(line), &rec); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if !drop(rec.Type, rec.Data) {
+				b.WriteString(line)
+			}
+		}
+		return b.String()
+	}
+	// a comment replied to, so dropping it leaves its replies dangling
+	var parentID float64
+	for _, line := range lines {
+		var rec struct{ Data struct{ ParentID *float64 } }
+		if json.Unmarshal([]byte(line), &rec) == nil && rec.Data.ParentID != nil {
+			parentID = *rec.Data.ParentID
+			break
+		}
+	}
+
+	whole := archive.String()
+	half := strings.Index(whole[len(whole)/2:], "\n") + len(whole)/2 + 10
+	tests := []struct {
+		name    string
+		archive string
+		wantMsg string
+	}{
+		{"truncated record", whole[:half], "unexpected EOF"},
+		{"not json", "{\"Type\":\"archive\"\nnot json\n", "invalid character"},
+		{"unknown record type", `{"Type":"reaction","Data":{}}` + "\n", `unknown record type "reaction"`},
+		{"unsupported version", `{"Type":"archive","Data":{"Version":2}}` + "\n", "unsupported version 2"},
+		{"dangling user", without(func(typ string, _ map[string]any) bool { return typ == "user" }), "unknown user"},
+		{"dangling tag", without(func(typ string, _ map[string]any) bool { return typ == "tag" }), "unknown tag"},
+		{"dangling parent comment", without(func(typ string, data map[string]any) bool {
+			return typ == "comment" && data["ID"] == parentID
+		}), "unknown parent comment"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dst := archiveTarget(t)
+			before := rowCounts(t, dst)
+
+			stats, err := ImportBlog(dst, strings.NewReader(tt.archive))
+			if !errors.Is(err, ErrInvalidArchive) {
+				t.Fatalf("got %+v, %v, want ErrInvalidArchive", stats, err)
+			}
+			if !strings.Contains(err.Error(), tt.wantMsg) {
+				t.Errorf("error %q, want it to mention %q", err, tt.wantMsg)
+			}
+			// nothing of the archive was written
+			if after := rowCounts(t, dst); !maps.Equal(before, after) {
+				t.Fatalf("%v rows before the import, %v after", before, after)
+			}
+		})
+	}
+}
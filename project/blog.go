@@ -1,11 +1,17 @@
 package project
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"math/rand"
+	"strings"
 	"time"
 
+	"gorm/dbtime"
+	"gorm/pagination"
+
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
@@ -14,16 +20,22 @@ import (
 func BlogTest() {
 	dsn := "db/blog.db"
 	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{
-		Logger: logger.Default.LogMode(logger.Info),
+		Logger:  logger.Default.LogMode(logger.Info),
+		NowFunc: dbtime.Now,
 	})
 	if err != nil {
 		panic(err)
 	}
 
-	if err := db.AutoMigrate(&User{}, &Post{}, &Tag{}, &Comment{}); err != nil {
+	if err := Migrate(db); err != nil {
 		panic(err)
 	}
 
+	// the demos write far more than a user would, RateLimitTest shows the quotas
+	cfg := DefaultConfig()
+	cfg.PostRateLimit, cfg.CommentRateLimit = RateLimit{}, RateLimit{}
+	db = db.WithContext(WithConfig(context.Background(), cfg))
+
 	if err := SeedBlogData(db); err != nil {
 		panic(err)
 	}
@@ -31,10 +43,30 @@ func BlogTest() {
 	GetUserLatestPostsTest(db)
 	CountPostCommentsTest(db)
 	PostWithTagsTest(db)
+	CommentThreadTest(db)
+	PostLifecycleTest(db)
+	PostRevisionTest(db)
+	MarkdownTest(db)
+	SyndicationTest(db)
+	PostViewTest(db)
+	OutboxTest(db)
+	RateLimitTest(db)
+	HomeFeedTest(db)
+	CascadeDeletePostTest(db)
+	ArchiveTest(db)
+	TagAdminTest(db)
+	CommentModerationTest(db)
+	ReactionTest(db)
 	SoftDeleteCommentTest(db)
 	HardDeleteCommentTest(db)
 }
 
+// ErrInvalidInput is returned when a required field is missing or malformed.
+var ErrInvalidInput = errors.New("invalid input")
+
+// ErrForbidden is returned when the user acting isn't allowed to do what was asked.
+var ErrForbidden = errors.New("forbidden")
+
 // ===== Tags =====
 var Tags = []Tag{
 	{Name: "Go"},
@@ -44,71 +76,36 @@ var Tags = []Tag{
 	{Name: "Cloud"},
 }
 
-func SeedBlogData(db *gorm.DB) error {
-	// ===== Users =====
-	users := make([]User, 5)
-	for i := range 5 {
-		users[i] = User{
-			Name:  fmt.Sprintf("user_%d", i+1),
-			Email: fmt.Sprintf("user_%d@test.com", i+1),
-		}
-	}
-	if err := db.Create(&users).Error; err != nil {
-		return err
+// CreateUser adds a user, emails are unique.
+func CreateUser(db *gorm.DB, name, email string) (*User, error) {
+	name, email = strings.TrimSpace(name), strings.TrimSpace(email)
+	if name == "" {
+		return nil, fmt.Errorf("%w: name is required", ErrInvalidInput)
 	}
-
-	if err := db.Create(&Tags).Error; err != nil {
-		return err
+	if !strings.Contains(email, "@") {
+		return nil, fmt.Errorf("%w: invalid email %q", ErrInvalidInput, email)
 	}
 
-	// ===== Posts =====
-	posts := make([]Post, 20)
-	for i := range 20 {
-		posts[i] = Post{
-			Subject: fmt.Sprintf("Post Subject %d", i+1),
-			Content: fmt.Sprintf("This is the content of post %d", i+1),
-			UserID:  users[i%len(users)].ID, // 平均分给 5 个用户
-		}
-	}
-	if err := db.Create(&posts).Error; err != nil {
-		return err
-	}
-
-	// ===== Post <-> Tags (many2many) =====
-	// 每篇文章随机挂 1~3 个标签
-	r := rand.New(rand.NewSource(time.Now().UnixNano()))
-
-	for i := range posts {
-		n := r.Intn(3) + 1
-		selected := make([]Tag, n)
-		for j := range n {
-			selected[j] = Tags[r.Intn(len(Tags))]
-		}
-
-		if err := db.Model(&posts[i]).Association("Tags").Append(selected); err != nil {
-			return err
-		}
+	u := User{Name: name, Email: email}
+	if err := db.Create(&u).Error; err != nil {
+		return nil, err
 	}
+	return &u, nil
+}
 
-	// ===== Comments =====
-	comments := make([]Comment, 10)
-	for i := range 10 {
-		comments[i] = Comment{
-			PostID:  posts[r.Intn(len(posts))].ID,
-			UserID:  users[r.Intn(len(users))].ID,
-			Content: fmt.Sprintf("Comment %d content", i+1),
-		}
-	}
-	if err := db.Create(&comments).Error; err != nil {
-		return err
+// ListUsers returns every user in ID order.
+func ListUsers(db *gorm.DB) ([]User, error) {
+	var users []User
+	if err := db.Order("id").Find(&users).Error; err != nil {
+		return nil, err
 	}
-
-	return nil
+	return users, nil
 }
 
 func GetUserLatestPosts(db *gorm.DB, userID uint, number int) ([]Post, error) {
 	var posts []Post
 	if err := db.
+		Scopes(visiblePosts).
 		Where("user_id = ?", userID).
 		Order("created_at DESC").
 		Limit(number).
@@ -119,6 +116,16 @@ func GetUserLatestPosts(db *gorm.DB, userID uint, number int) ([]Post, error) {
 	return posts, nil
 }
 
+// GetUserLatestPostsPage is the cursor based variant of GetUserLatestPosts.
+// Pass the NextCursor (or PrevCursor) of the previous page to move on, an empty cursor starts from the latest post.
+// The user_id filter and the (created_at, id) order are both served by the idx_user_created index.
+func GetUserLatestPostsPage(db *gorm.DB, userID uint, cursor string, number int) (*pagination.KeysetPage[Post], error) {
+	return pagination.Paginate[Post](
+		db.Scopes(visiblePosts).Where("user_id = ?", userID).Preload("Tags"),
+		pagination.Keyset{Desc: true, Cursor: cursor, Limit: number},
+	)
+}
+
 func GetUserLatestPostsTest(db *gorm.DB) {
 	userID := uint(1)
 	numOfPosts := 10
@@ -145,7 +152,8 @@ func CountPostComments(db *gorm.DB) ([]PostWithCommentCount, error) {
 
 	if err := db.Model(&Post{}).
 		Select("posts.*, COUNT(comments.id) AS comment_count").
-		Joins("LEFT JOIN comments ON comments.post_id = posts.id").
+		// raw joins skip the default scopes, count the publicly visible comments only
+		Joins("LEFT JOIN comments ON comments.post_id = posts.id AND comments.status = ? AND comments.deleted_at IS NULL", CommentApproved).
 		Group("posts.id").
 		Scan(&result).Error; err != nil {
 		return nil, err
@@ -167,12 +175,86 @@ func CountPostCommentsTest(db *gorm.DB) {
 	fmt.Println(string(b))
 }
 
+func validatePost(subject, content string) error {
+	if strings.TrimSpace(subject) == "" {
+		return fmt.Errorf("%w: subject is required", ErrInvalidInput)
+	}
+	if strings.TrimSpace(content) == "" {
+		return fmt.Errorf("%w: content is required", ErrInvalidInput)
+	}
+	return nil
+}
+
+// CreatePost creates a draft without tags, see lifecycle.go to publish it.
+func CreatePost(db *gorm.DB, userID uint, subject, content string) (*Post, error) {
+	if err := validatePost(subject, content); err != nil {
+		return nil, err
+	}
+
+	p := Post{
+		Subject: subject,
+		Content: content,
+		UserID:  userID,
+		Status:  PostDraft,
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// make sure the author exists, First reports gorm.ErrRecordNotFound otherwise
+		if err := tx.First(&User{}, userID).Error; err != nil {
+			return err // roll back
+		}
+		if err := configFrom(tx.Statement.Context).PostRateLimit.allow(tx, actionPost, userID); err != nil {
+			return err // roll back
+		}
+		return tx.Create(&p).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// ListPosts returns the latest posts of all users with their tags.
+func ListPosts(db *gorm.DB, number int) ([]Post, error) {
+	var posts []Post
+	if err := db.
+		Scopes(visiblePosts).
+		Order("created_at DESC").
+		Limit(number).
+		Preload("Tags").
+		Find(&posts).Error; err != nil {
+		return nil, err
+	}
+	return posts, nil
+}
+
+// GetPost loads a single post with its tags and comments.
+func GetPost(db *gorm.DB, postID uint) (*Post, error) {
+	var p Post
+	if err := db.Scopes(visiblePosts).Preload("Tags").Preload("Comments").First(&p, postID).Error; err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
 func PublishPostWithTags(
 	db *gorm.DB,
 	userID uint,
 	subject, content string,
-	tagIDs []uint) error {
-	return db.Transaction(func(tx *gorm.DB) error {
+	tagIDs []uint) (*Post, error) {
+	if err := validatePost(subject, content); err != nil {
+		return nil, err
+	}
+
+	var p Post
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// make sure the author exists
+		if err := tx.First(&User{}, userID).Error; err != nil {
+			return err // roll back
+		}
+		if err := configFrom(tx.Statement.Context).PostRateLimit.allow(tx, actionPost, userID); err != nil {
+			return err // roll back
+		}
+
 		// find tags
 		var tags []Tag
 		if len(tagIDs) > 0 {
@@ -182,10 +264,13 @@ func PublishPostWithTags(
 		}
 
 		// create a post
-		p := Post{
-			Subject: subject,
-			Content: content,
-			UserID:  userID,
+		now := tx.NowFunc()
+		p = Post{
+			Subject:     subject,
+			Content:     content,
+			UserID:      userID,
+			Status:      PostPublished,
+			PublishedAt: &now,
 		}
 		if err := tx.Create(&p).Error; err != nil {
 			return err // roll back
@@ -196,6 +281,10 @@ func PublishPostWithTags(
 			return err // roll back
 		}
 
+		if err := onPublished(tx, p.ID); err != nil {
+			return err // roll back
+		}
+
 		// or create with association
 		// p1 := Post{
 		// 	Subject: subject,
@@ -210,6 +299,10 @@ func PublishPostWithTags(
 		// Commit
 		return nil
 	})
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
 }
 
 func PostWithTagsTest(db *gorm.DB) {
@@ -232,7 +325,7 @@ func PostWithTagsTest(db *gorm.DB) {
 	}
 
 	// publish
-	if err := PublishPostWithTags(db, uint(userID), "This is a new Post", "Just wanna say hello web3!", tagIDs); err != nil {
+	if _, err := PublishPostWithTags(db, uint(userID), "This is a new Post", "Just wanna say hello web3!", tagIDs); err != nil {
 		panic(err)
 	}
 
@@ -246,8 +339,48 @@ func PostWithTagsTest(db *gorm.DB) {
 	fmt.Println(string(b))
 }
 
+// AddComment adds a comment written by userID to a post.
+func AddComment(db *gorm.DB, postID, userID uint, content string) (*Comment, error) {
+	if strings.TrimSpace(content) == "" {
+		return nil, fmt.Errorf("%w: content is required", ErrInvalidInput)
+	}
+
+	c := Comment{
+		PostID:  postID,
+		UserID:  userID,
+		Content: content,
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// only posts the commenter can see
+		if err := tx.Scopes(visiblePosts).First(&Post{}, postID).Error; err != nil {
+			return err // roll back
+		}
+		if err := tx.First(&User{}, userID).Error; err != nil {
+			return err // roll back
+		}
+		if err := configFrom(tx.Statement.Context).CommentRateLimit.allow(tx, actionComment, userID); err != nil {
+			return err // roll back
+		}
+		if err := tx.Create(&c).Error; err != nil {
+			return err // roll back
+		}
+		// held comments are notified on approval
+		if c.Status == CommentApproved {
+			return onCommentApproved(tx, c.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
 func SoftDeleteComment(db *gorm.DB, commentID uint) error {
 	return db.Transaction(func(tx *gorm.DB) error {
+		// pending and rejected comments can be deleted as well
+		tx = tx.WithContext(IncludeUnapproved(tx.Statement.Context))
+
 		var comment Comment
 		if err := tx.First(&comment, commentID).Error; err != nil {
 			return err // roll back
@@ -303,12 +436,30 @@ func SoftDeleteCommentTest(db *gorm.DB) {
 
 func HardDeleteComment(db *gorm.DB, commentID uint) error {
 	return db.Transaction(func(tx *gorm.DB) error {
+		// Unscoped so already soft-deleted comments can be purged as well
 		var comment Comment
-		if err := tx.First(&comment, commentID).Error; err != nil {
+		if err := tx.Unscoped().First(&comment, commentID).Error; err != nil {
+			return err // roll back
+		}
+
+		// remove the replies too, the thread can't reach them once their parent is gone
+		var subtree []uint
+		if err := tx.Raw(`
+			WITH RECURSIVE subtree(id) AS (
+				SELECT ?
+				UNION ALL
+				SELECT c.id FROM comments c JOIN subtree ON c.parent_id = subtree.id
+			)
+			SELECT id FROM subtree
+		`, comment.ID).Scan(&subtree).Error; err != nil {
 			return err // roll back
 		}
 
-		if err := tx.Unscoped().Delete(&comment).Error; err != nil { // use Unscoped for hard delete
+		// and their reactions, nothing would point at them anymore
+		if err := tx.Where("target_type = ? AND target_id IN ?", ReactionOnComment, subtree).Delete(&Reaction{}).Error; err != nil {
+			return err // roll back
+		}
+		if err := tx.Unscoped().Where("id IN ?", subtree).Delete(&Comment{}).Error; err != nil {
 			return err // roll back
 		}
 
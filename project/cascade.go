@@ -0,0 +1,101 @@
+// Cascading soft delete of posts
+//
+// DeletePost soft deletes a post together with its live comments, in one transaction:
+//
+// UPDATE posts SET deleted_at = now WHERE id = 1 AND deleted_at IS NULL;
+// UPDATE comments SET deleted_at = now, deleted_with_post = true WHERE post_id = 1 AND deleted_at IS NULL;
+//
+// The post_tags rows stay, every query on posts skips deleted posts so the post disappears from
+// tag listings, search and feeds, and comes back with its tags on restore.
+//
+// Comments deleted on their own before the post keep deleted_with_post = false,
+// RestorePost only brings back the comments the cascade deleted.
+
+package project
+
+import (
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// DeletePost soft deletes a post and its comments.
+func DeletePost(db *gorm.DB, postID uint) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		now := tx.NowFunc()
+		result := tx.Model(&Post{}).Where("id = ?", postID).Update("deleted_at", now)
+		if result.Error != nil {
+			return result.Error // roll back
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound // missing or already deleted, roll back
+		}
+
+		// the soft delete clause keeps comments deleted earlier out of the cascade
+		return tx.Model(&Comment{}).
+			Where("post_id = ?", postID).
+			Updates(map[string]any{"deleted_at": now, "deleted_with_post": true}).Error
+	})
+}
+
+// RestorePost brings back a soft deleted post and the comments deleted with it.
+func RestorePost(db *gorm.DB, postID uint) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Unscoped().Model(&Post{}).
+			Where("id = ? AND deleted_at IS NOT NULL", postID).
+			Update("deleted_at", nil)
+		if result.Error != nil {
+			return result.Error // roll back
+		}
+		if result.RowsAffected == 0 {
+			if err := tx.Unscoped().Select("id").First(&Post{}, postID).Error; err != nil {
+				return err // roll back
+			}
+			return fmt.Errorf("%w: post %d is not deleted", ErrInvalidTransition, postID) // roll back
+		}
+
+		return tx.Unscoped().Model(&Comment{}).
+			Where("post_id = ? AND deleted_with_post = ?", postID, true).
+			Updates(map[string]any{"deleted_at": nil, "deleted_with_post": false}).Error
+	})
+}
+
+func CascadeDeletePostTest(db *gorm.DB) {
+	p, err := PublishPostWithTags(db, 1, "Short-lived", "Deleted with its comments", []uint{1})
+	if err != nil {
+		panic(err)
+	}
+	first, err := AddComment(db, p.ID, 2, "First!")
+	if err != nil {
+		panic(err)
+	}
+	if _, err := AddComment(db, p.ID, 3, "Nice"); err != nil {
+		panic(err)
+	}
+	// deleted on its own, stays deleted after the restore
+	if err := SoftDeleteComment(db, first.ID); err != nil {
+		panic(err)
+	}
+
+	if err := DeletePost(db, p.ID); err != nil {
+		panic(err)
+	}
+	tagged, err := GetTagPosts(db, Slugify(Tags[0].Name), 100)
+	if err != nil {
+		panic(err)
+	}
+	for _, tp := range tagged {
+		if tp.ID == p.ID {
+			panic("deleted post still listed under its tag")
+		}
+	}
+
+	if err := RestorePost(db, p.ID); err != nil {
+		panic(err)
+	}
+	restored, err := GetPost(db, p.ID)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("restored post %d with %d comment(s)\n", restored.ID, len(restored.Comments))
+}
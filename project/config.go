@@ -0,0 +1,64 @@
+// Blog configuration
+//
+// The settings of the blog domain are a Config carried by the context of the *gorm.DB, like the acting user
+// of WithUserID, so callers sharing a database can each run with their own settings:
+//
+// db = db.WithContext(project.WithConfig(ctx, cfg))
+// p, err := project.CreatePost(db, userID, subject, content) // limited by cfg.PostRateLimit
+//
+// The API carries API.Config into every request. Without a Config in the context, DefaultConfig applies.
+
+package project
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type Config struct {
+	PostRateLimit    RateLimit // posts created or published per user
+	CommentRateLimit RateLimit // comments and replies per user
+	Moderation       ModerationPolicy
+	MaxCommentDepth  int // deepest level a reply can be created at, top-level comments are at depth 0
+
+	// FeedFanOutOnWrite makes publishing fill the feed_items of the author's followers, see MaterializedHomeFeed.
+	FeedFanOutOnWrite bool
+}
+
+// DefaultConfig returns the settings used when the context carries none.
+func DefaultConfig() Config {
+	return Config{
+		PostRateLimit:    RateLimit{Limit: 20, Window: 24 * time.Hour},
+		CommentRateLimit: RateLimit{Limit: 5, Window: time.Minute},
+		MaxCommentDepth:  5,
+	}
+}
+
+type ctxKeyConfig struct{}
+
+// WithConfig makes the writes run with ctx use cfg.
+func WithConfig(ctx context.Context, cfg Config) context.Context {
+	return context.WithValue(ctx, ctxKeyConfig{}, cfg)
+}
+
+// inheritConfig gives ctx the Config of db when it carries none, for the loops given a db once and a ctx per run.
+func inheritConfig(ctx context.Context, db *gorm.DB) context.Context {
+	if _, ok := ctx.Value(ctxKeyConfig{}).(Config); ok || db.Statement.Context == nil {
+		return ctx
+	}
+	if cfg, ok := db.Statement.Context.Value(ctxKeyConfig{}).(Config); ok {
+		return WithConfig(ctx, cfg)
+	}
+	return ctx
+}
+
+func configFrom(ctx context.Context) Config {
+	if ctx != nil {
+		if cfg, ok := ctx.Value(ctxKeyConfig{}).(Config); ok {
+			return cfg
+		}
+	}
+	return DefaultConfig()
+}
@@ -0,0 +1,239 @@
+// Follow graph and home feed
+//
+// A user's home feed lists the latest published posts of everyone they follow. It can be built two ways:
+//
+// 1. Fan-out on read (HomeFeed): query the posts of the followees on every request.
+//
+// SELECT * FROM posts
+// WHERE status = 'published' AND user_id IN (SELECT followee_id FROM follows WHERE follower_id = ?)
+// ORDER BY published_at DESC, id DESC LIMIT 11;
+//
+// Publishing is cheap, reading gets slower as users follow more people.
+//
+// 2. Fan-out on write (MaterializedHomeFeed): with Config.FeedFanOutOnWrite, publishing a post copies it into
+// the feed_items of every follower, in the publishing transaction. Reading is a single index range scan
+// on (user_id, published_at), publishing costs one row per follower.
+//
+// | user_id | post_id | author_id | published_at        |
+// | ------- | ------- | --------- | ------------------- |
+// | 2       | 7       | 1         | 2024-01-01 10:00:00 |
+// | 3       | 7       | 1         | 2024-01-01 10:00:00 |
+//
+// Posts leaving the published state or deleted keep their feed items, the read joins posts to skip them.
+// RebuildFeed recomputes the table from follows and posts, e.g. after turning fan-out on write on.
+
+package project
+
+import (
+	"fmt"
+	"time"
+
+	"gorm/pagination"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type Follow struct {
+	FollowerID uint      `gorm:"primaryKey"`              // FK to the user who follows
+	FolloweeID uint      `gorm:"primaryKey;index"`        // FK to the user being followed
+	CreatedAt  time.Time `gorm:"autoCreateTime;not null"` // when the follow started
+}
+
+type FeedItem struct {
+	ID          uint      `gorm:"primaryKey"`
+	UserID      uint      `gorm:"not null;uniqueIndex:idx_feed_user_post,priority:1;index:idx_feed_user_published,priority:1"` // FK to the feed owner
+	PostID      uint      `gorm:"not null;uniqueIndex:idx_feed_user_post,priority:2;index"`                                    // FK
+	AuthorID    uint      `gorm:"not null"`                                                                                    // FK, lets Unfollow remove the author's posts
+	PublishedAt time.Time `gorm:"not null;index:idx_feed_user_published,priority:2"`
+}
+
+// FollowUser makes followerID follow followeeID, following twice is a no-op.
+func FollowUser(db *gorm.DB, followerID, followeeID uint) error {
+	if followerID == followeeID {
+		return fmt.Errorf("%w: users cannot follow themselves", ErrInvalidInput)
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		var n int64
+		if err := tx.Model(&User{}).Where("id IN ?", []uint{followerID, followeeID}).Count(&n).Error; err != nil {
+			return err // roll back
+		}
+		if n != 2 {
+			return gorm.ErrRecordNotFound // roll back
+		}
+
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).
+			Create(&Follow{FollowerID: followerID, FolloweeID: followeeID})
+		if result.Error != nil || result.RowsAffected == 0 || !configFrom(tx.Statement.Context).FeedFanOutOnWrite {
+			return result.Error
+		}
+
+		// the author's earlier posts weren't fanned out to the new follower
+		return tx.Exec(`
+			INSERT INTO feed_items (user_id, post_id, author_id, published_at)
+			SELECT ?, id, user_id, published_at FROM posts
+			WHERE user_id = ? AND status = ? AND published_at IS NOT NULL AND deleted_at IS NULL
+			ON CONFLICT (user_id, post_id) DO NOTHING
+		`, followerID, followeeID, PostPublished).Error
+	})
+}
+
+// UnfollowUser stops followerID from following followeeID.
+func UnfollowUser(db *gorm.DB, followerID, followeeID uint) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("follower_id = ? AND followee_id = ?", followerID, followeeID).Delete(&Follow{}).Error; err != nil {
+			return err // roll back
+		}
+		return tx.Where("user_id = ? AND author_id = ?", followerID, followeeID).Delete(&FeedItem{}).Error
+	})
+}
+
+// Following returns the users followed by userID.
+func Following(db *gorm.DB, userID uint) ([]User, error) {
+	var users []User
+	if err := db.
+		Joins("JOIN follows ON follows.followee_id = users.id").
+		Where("follows.follower_id = ?", userID).
+		Order("users.id").
+		Find(&users).Error; err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// Followers returns the users following userID.
+func Followers(db *gorm.DB, userID uint) ([]User, error) {
+	var users []User
+	if err := db.
+		Joins("JOIN follows ON follows.follower_id = users.id").
+		Where("follows.followee_id = ?", userID).
+		Order("users.id").
+		Find(&users).Error; err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// fanOutPost copies a just published post into the feeds of its author's followers.
+func fanOutPost(tx *gorm.DB, postID uint) error {
+	var p Post
+	if err := tx.Select("id", "user_id", "published_at").First(&p, postID).Error; err != nil {
+		return err
+	}
+
+	// republished posts move back to the top of the feeds
+	return tx.Exec(`
+		INSERT INTO feed_items (user_id, post_id, author_id, published_at)
+		SELECT follower_id, ?, ?, ? FROM follows WHERE followee_id = ?
+		ON CONFLICT (user_id, post_id) DO UPDATE SET published_at = excluded.published_at
+	`, p.ID, p.UserID, p.PublishedAt, p.UserID).Error
+}
+
+// RebuildFeed recomputes the feed_items of every user from follows and published posts.
+func RebuildFeed(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("1 = 1").Delete(&FeedItem{}).Error; err != nil {
+			return err // roll back
+		}
+		return tx.Exec(`
+			INSERT INTO feed_items (user_id, post_id, author_id, published_at)
+			SELECT follows.follower_id, posts.id, posts.user_id, posts.published_at
+			FROM follows JOIN posts ON posts.user_id = follows.followee_id
+			WHERE posts.status = ? AND posts.published_at IS NOT NULL AND posts.deleted_at IS NULL
+		`, PostPublished).Error
+	})
+}
+
+// HomeFeed returns the latest published posts of the users followed by userID, computed on read.
+// Pass the NextCursor (or PrevCursor) of the previous page to move on.
+func HomeFeed(db *gorm.DB, userID uint, cursor string, number int) (*pagination.KeysetPage[Post], error) {
+	return pagination.Paginate[Post](
+		db.
+			Where("posts.status = ? AND posts.published_at IS NOT NULL", PostPublished).
+			Where("posts.user_id IN (?)", db.Session(&gorm.Session{NewDB: true}).
+				Model(&Follow{}).Select("followee_id").Where("follower_id = ?", userID)).
+			Preload("Tags"),
+		pagination.Keyset{Column: "published_at", Desc: true, Cursor: cursor, Limit: number},
+	)
+}
+
+// MaterializedHomeFeed is HomeFeed read from feed_items, see Config.FeedFanOutOnWrite.
+// Its cursors point into feed_items and can't be mixed with the cursors of HomeFeed.
+func MaterializedHomeFeed(db *gorm.DB, userID uint, cursor string, number int) (*pagination.KeysetPage[Post], error) {
+	items, err := pagination.Paginate[FeedItem](
+		db.
+			Joins("JOIN posts ON posts.id = feed_items.post_id AND posts.status = ? AND posts.deleted_at IS NULL", PostPublished).
+			Where("feed_items.user_id = ?", userID),
+		pagination.Keyset{Column: "published_at", Desc: true, Cursor: cursor, Limit: number},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &pagination.KeysetPage[Post]{
+		Items:      make([]Post, 0, len(items.Items)),
+		NextCursor: items.NextCursor,
+		PrevCursor: items.PrevCursor,
+	}
+	if len(items.Items) == 0 {
+		return page, nil
+	}
+
+	ids := make([]uint, len(items.Items))
+	for i, it := range items.Items {
+		ids[i] = it.PostID
+	}
+	var posts []Post
+	if err := db.Preload("Tags").Find(&posts, ids).Error; err != nil {
+		return nil, err
+	}
+	byID := make(map[uint]Post, len(posts))
+	for _, p := range posts {
+		byID[p.ID] = p
+	}
+
+	// keep the feed order
+	for _, id := range ids {
+		if p, ok := byID[id]; ok {
+			page.Items = append(page.Items, p)
+		}
+	}
+	return page, nil
+}
+
+func HomeFeedTest(db *gorm.DB) {
+	cfg := configFrom(db.Statement.Context)
+	cfg.FeedFanOutOnWrite = true
+	db = db.WithContext(WithConfig(db.Statement.Context, cfg))
+
+	if err := RebuildFeed(db); err != nil {
+		panic(err)
+	}
+	if err := FollowUser(db, 1, 2); err != nil {
+		panic(err)
+	}
+	if _, err := PublishPostWithTags(db, 2, "Fan-out", "Copied into the feeds of my followers", nil); err != nil {
+		panic(err)
+	}
+
+	start := time.Now()
+	onRead, err := HomeFeed(db, 1, "", 5)
+	if err != nil {
+		panic(err)
+	}
+	readTook := time.Since(start)
+
+	start = time.Now()
+	onWrite, err := MaterializedHomeFeed(db, 1, "", 5)
+	if err != nil {
+		panic(err)
+	}
+	writeTook := time.Since(start)
+
+	fmt.Printf("fan-out on read: %d posts in %v, fan-out on write: %d posts in %v\n",
+		len(onRead.Items), readTook, len(onWrite.Items), writeTook)
+	for _, p := range onWrite.Items {
+		fmt.Printf("  #%d %s\n", p.ID, p.Subject)
+	}
+}
@@ -0,0 +1,77 @@
+package project
+
+import (
+	"context"
+	"testing"
+
+	"gorm/dbtime"
+	"gorm/pagination"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// openTestDB returns a migrated in-memory database running with cfg.
+func openTestDB(tb testing.TB, cfg Config) *gorm.DB {
+	tb.Helper()
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{
+		Logger:  logger.Default.LogMode(logger.Silent),
+		NowFunc: dbtime.Now,
+	})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	// a single connection, every new connection would open another empty in-memory database
+	sqlDB.SetMaxOpenConns(1)
+	tb.Cleanup(func() { sqlDB.Close() })
+
+	db = db.WithContext(WithConfig(context.Background(), cfg))
+	if err := Migrate(db); err != nil {
+		tb.Fatal(err)
+	}
+	return db
+}
+
+// feedBenchSeed is large enough for the follow graph to matter: up to 50 followees of 200 posts each.
+var feedBenchSeed = SeedOptions{
+	Seed:        1,
+	Users:       200,
+	Posts:       40000,
+	TagsPerPost: 2,
+	Follows:     50,
+}
+
+func benchmarkHomeFeed(b *testing.B, fanOutOnWrite bool,
+	read func(db *gorm.DB, userID uint, cursor string, number int) (*pagination.KeysetPage[Post], error)) {
+	cfg := DefaultConfig()
+	cfg.FeedFanOutOnWrite = fanOutOnWrite // Seed fills feed_items with it
+	db := openTestDB(b, cfg)
+	seeded, err := Seed(db, feedBenchSeed)
+	if err != nil {
+		b.Fatal(err)
+	}
+	userID := seeded.UserIDs[0]
+
+	for b.Loop() {
+		page, err := read(db, userID, "", 20)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if len(page.Items) == 0 {
+			b.Fatal("empty home feed")
+		}
+	}
+}
+
+func BenchmarkHomeFeedOnRead(b *testing.B) {
+	benchmarkHomeFeed(b, false, HomeFeed)
+}
+
+func BenchmarkHomeFeedMaterialized(b *testing.B) {
+	benchmarkHomeFeed(b, true, MaterializedHomeFeed)
+}
@@ -0,0 +1,225 @@
+// Post lifecycle
+//
+// draft --schedule--> scheduled --(Scheduler)--> published --archive--> archived
+//
+// | to        | allowed from                   |
+// | --------- | ------------------------------ |
+// | draft     | scheduled, published, archived |
+// | scheduled | draft                          |
+// | published | draft, scheduled, archived     |
+// | archived  | published                      |
+//
+// Only published posts are visible to everyone, the author also sees their drafts, scheduled and archived posts.
+// Transitions are atomic conditional updates:
+//
+// UPDATE posts SET status = 'archived' WHERE id = ? AND status IN ('published')
+//
+// so two concurrent transitions can't both succeed from the same state.
+
+package project
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type PostStatus string
+
+const (
+	PostDraft     PostStatus = "draft"
+	PostScheduled PostStatus = "scheduled"
+	PostPublished PostStatus = "published"
+	PostArchived  PostStatus = "archived"
+)
+
+var ErrInvalidTransition = errors.New("invalid post status transition")
+
+// allowed source states of every target state
+var transitions = map[PostStatus][]PostStatus{
+	PostDraft:     {PostScheduled, PostPublished, PostArchived},
+	PostScheduled: {PostDraft},
+	PostPublished: {PostDraft, PostScheduled, PostArchived},
+	PostArchived:  {PostPublished},
+}
+
+type ctxKeyUserID struct{} // typed context key to prevent collision
+
+// WithUserID marks ctx as acting on behalf of userID, read paths then also return that user's unpublished posts.
+func WithUserID(ctx context.Context, userID uint) context.Context {
+	return context.WithValue(ctx, ctxKeyUserID{}, userID)
+}
+
+func userIDFrom(ctx context.Context) (uint, bool) {
+	if ctx == nil {
+		return 0, false
+	}
+	uid, ok := ctx.Value(ctxKeyUserID{}).(uint)
+	return uid, ok && uid != 0
+}
+
+// visiblePosts keeps published posts, plus every post of the user found in the statement context.
+func visiblePosts(db *gorm.DB) *gorm.DB {
+	if uid, ok := userIDFrom(db.Statement.Context); ok {
+		return db.Where("(posts.status = ? OR posts.user_id = ?)", PostPublished, uid)
+	}
+	return db.Where("posts.status = ?", PostPublished)
+}
+
+// transitionPost moves a post to status `to`, if its current status is one of from.
+func transitionPost(tx *gorm.DB, postID uint, from []PostStatus, to PostStatus, updates map[string]any) error {
+	updates["status"] = to
+
+	result := tx.Model(&Post{}).
+		Where("id = ? AND status IN ?", postID, from).
+		Updates(updates)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected > 0 {
+		return nil
+	}
+
+	// nothing updated, either the post doesn't exist or it is in the wrong state
+	var p Post
+	if err := tx.Select("id", "status").First(&p, postID).Error; err != nil {
+		return err
+	}
+	return fmt.Errorf("%w: %s -> %s", ErrInvalidTransition, p.Status, to)
+}
+
+// SchedulePost makes a draft go live at `at`, see Scheduler.
+func SchedulePost(db *gorm.DB, postID uint, at time.Time) error {
+	return transitionPost(db, postID, transitions[PostScheduled], PostScheduled, map[string]any{
+		"scheduled_at": at.UTC(),
+	})
+}
+
+// PublishPost makes a draft, scheduled or archived post visible to everyone now.
+func PublishPost(db *gorm.DB, postID uint) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		return publishPost(tx, postID, transitions[PostPublished])
+	})
+}
+
+func publishPost(tx *gorm.DB, postID uint, from []PostStatus) error {
+	if err := transitionPost(tx, postID, from, PostPublished, map[string]any{
+		"published_at": tx.NowFunc(),
+	}); err != nil {
+		return err
+	}
+	return onPublished(tx, postID)
+}
+
+// onPublished runs in the transaction of every path that makes a post published,
+// side effects added here commit or roll back together with the post.
+func onPublished(tx *gorm.DB, postID uint) error {
+	if configFrom(tx.Statement.Context).FeedFanOutOnWrite {
+		if err := fanOutPost(tx, postID); err != nil {
+			return err
+		}
+	}
+	return notifyPostPublished(tx, postID)
+}
+
+// ArchivePost hides a published post from everyone but its author.
+func ArchivePost(db *gorm.DB, postID uint) error {
+	return transitionPost(db, postID, transitions[PostArchived], PostArchived, map[string]any{})
+}
+
+// RevertToDraft takes a scheduled, published or archived post back to draft.
+func RevertToDraft(db *gorm.DB, postID uint) error {
+	return transitionPost(db, postID, transitions[PostDraft], PostDraft, map[string]any{
+		"scheduled_at": nil,
+		"published_at": nil,
+	})
+}
+
+// Scheduler publishes scheduled posts once their time has come.
+type Scheduler struct {
+	db       *gorm.DB
+	interval time.Duration
+}
+
+func NewScheduler(db *gorm.DB, interval time.Duration) *Scheduler {
+	return &Scheduler{db: db, interval: interval}
+}
+
+// Run publishes due posts every interval until ctx is done.
+// Posts are published with the Config of ctx, or else the one carried by the db given to NewScheduler.
+func (s *Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := s.PublishDue(ctx); err != nil {
+			s.db.Logger.Error(ctx, "failed to publish scheduled posts, %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// PublishDue publishes every scheduled post whose time has come and returns how many were published.
+// Each post is published in its own transaction, a failure doesn't hold back the others.
+func (s *Scheduler) PublishDue(ctx context.Context) (int, error) {
+	db := s.db.WithContext(inheritConfig(ctx, s.db))
+
+	var ids []uint
+	if err := db.Model(&Post{}).
+		Where("status = ? AND scheduled_at <= ?", PostScheduled, db.NowFunc()).
+		Order("scheduled_at").
+		Pluck("id", &ids).Error; err != nil {
+		return 0, err
+	}
+
+	published := 0
+	var errs []error
+	for _, id := range ids {
+		err := db.Transaction(func(tx *gorm.DB) error {
+			return publishPost(tx, id, []PostStatus{PostScheduled})
+		})
+		switch {
+		case err == nil:
+			published++
+		case errors.Is(err, ErrInvalidTransition), errors.Is(err, gorm.ErrRecordNotFound):
+			// rescheduled, reverted or deleted since we listed it
+		default:
+			errs = append(errs, fmt.Errorf("post %d: %w", id, err))
+		}
+	}
+	return published, errors.Join(errs...)
+}
+
+func PostLifecycleTest(db *gorm.DB) {
+	p, err := CreatePost(db, 1, "Coming soon", "Drafts are only visible to their author")
+	if err != nil {
+		panic(err)
+	}
+
+	// anonymous readers don't see the draft, its author does
+	var n, m int64
+	db.Model(&Post{}).Scopes(visiblePosts).Where("id = ?", p.ID).Count(&n)
+	db.WithContext(WithUserID(context.Background(), 1)).Model(&Post{}).Scopes(visiblePosts).Where("id = ?", p.ID).Count(&m)
+	fmt.Printf("visible to anonymous: %d, visible to author: %d\n", n, m)
+
+	if err := SchedulePost(db, p.ID, time.Now().Add(-time.Second)); err != nil {
+		panic(err)
+	}
+	published, err := NewScheduler(db, time.Minute).PublishDue(context.Background())
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println("published scheduled posts:", published)
+
+	if err := SchedulePost(db, p.ID, time.Now()); err != nil {
+		fmt.Println("expected error:", err)
+	}
+}
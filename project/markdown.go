@@ -0,0 +1,399 @@
+// Markdown posts
+//
+// Post.Content is Markdown. Its HTML is cached in the content_html column, rendered by the BeforeSave hook
+// whenever Content is written, so reads never render:
+//
+// | content                     | content_html                                                          |
+// | --------------------------- | --------------------------------------------------------------------- |
+// | # Hello                     | <h1>Hello</h1>                                                        |
+// | See [docs](https://gorm.io) | <p>See <a href="https://gorm.io" rel="nofollow noopener">docs</a></p> |
+// | <script>alert(1)</script>   | <p>&lt;script&gt;alert(1)&lt;/script&gt;</p>                          |
+//
+// RenderMarkdown supports a safe subset of Markdown: ATX headings, paragraphs, *emphasis*, **strong**,
+// `code`, fenced code blocks, bullet and numbered lists, blockquotes, rules and [links](url).
+// The output is sanitized by construction: all text is HTML escaped and raw HTML is never passed through,
+// links keep only http, https, mailto and relative URLs (others render as their text), images render as links.
+//
+// Writes that skip the hooks (UpdateColumn, raw SQL) leave a stale cache behind,
+// RenderPostsHTML (blog render-posts) re-renders existing posts.
+
+package project
+
+import (
+	"fmt"
+	"html"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+var (
+	headingRe      = regexp.MustCompile(`^ {0,3}(#{1,6})\s+(.*)$`)
+	ruleRe         = regexp.MustCompile(`^ {0,3}(?:(?:- *){3,}|(?:\* *){3,}|(?:_ *){3,})$`)
+	fenceRe        = regexp.MustCompile("^ {0,3}```\\s*([\\w+-]*)")
+	closingFenceRe = regexp.MustCompile("^ {0,3}```\\s*$")
+	quoteRe        = regexp.MustCompile(`^ {0,3}> ?(.*)$`)
+	bulletRe       = regexp.MustCompile(`^ {0,3}[-*+]\s+(.*)$`)
+	orderedRe      = regexp.MustCompile(`^ {0,3}(\d{1,9})[.)]\s+(.*)$`)
+)
+
+// markdown punctuation a backslash turns into a literal character
+const escapable = "\\`*_[]()#+-.!>"
+
+// RenderMarkdown renders Markdown to sanitized HTML, see the supported subset above.
+func RenderMarkdown(src string) string {
+	var b strings.Builder
+	renderBlocks(&b, strings.Split(strings.ReplaceAll(src, "\r\n", "\n"), "\n"))
+	return b.String()
+}
+
+// startsBlock reports whether line opens a block other than a paragraph.
+func startsBlock(line string) bool {
+	return fenceRe.MatchString(line) || headingRe.MatchString(line) || ruleRe.MatchString(line) ||
+		quoteRe.MatchString(line) || bulletRe.MatchString(line) || orderedRe.MatchString(line)
+}
+
+func renderBlocks(b *strings.Builder, lines []string) {
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		switch {
+		case strings.TrimSpace(line) == "":
+			i++
+
+		case fenceRe.MatchString(line):
+			lang := fenceRe.FindStringSubmatch(line)[1]
+			start := i + 1
+			for i = start; i < len(lines) && !closingFenceRe.MatchString(lines[i]); i++ {
+			}
+			code := strings.Join(lines[start:min(i, len(lines))], "\n")
+			i++ // the closing fence, an unclosed block runs to the end
+
+			b.WriteString("<pre><code")
+			if lang != "" {
+				fmt.Fprintf(b, ` class="language-%s"`, lang) // [\w+-] only, nothing to escape
+			}
+			b.WriteString(">")
+			b.WriteString(html.EscapeString(code))
+			b.WriteString("</code></pre>\n")
+
+		case headingRe.MatchString(line):
+			m := headingRe.FindStringSubmatch(line)
+			fmt.Fprintf(b, "<h%d>%s</h%d>\n", len(m[1]), renderInline(strings.TrimSpace(m[2])), len(m[1]))
+			i++
+
+		// before lists, "* * *" is a rule
+		case ruleRe.MatchString(line):
+			b.WriteString("<hr>\n")
+			i++
+
+		case quoteRe.MatchString(line):
+			var quoted []string
+			for ; i < len(lines) && quoteRe.MatchString(lines[i]); i++ {
+				quoted = append(quoted, quoteRe.FindStringSubmatch(lines[i])[1])
+			}
+			b.WriteString("<blockquote>\n")
+			renderBlocks(b, quoted)
+			b.WriteString("</blockquote>\n")
+
+		case bulletRe.MatchString(line), orderedRe.MatchString(line):
+			i = renderList(b, lines, i)
+
+		default:
+			var para []string
+			for ; i < len(lines) && strings.TrimSpace(lines[i]) != "" && (len(para) == 0 || !startsBlock(lines[i])); i++ {
+				para = append(para, strings.TrimSpace(lines[i]))
+			}
+			fmt.Fprintf(b, "<p>%s</p>\n", renderInline(strings.Join(para, "\n")))
+		}
+	}
+}
+
+// renderList renders the list starting at lines[i] and returns the index of the line after it.
+// Items are one level deep, indented lines continue the previous item.
+func renderList(b *strings.Builder, lines []string, i int) int {
+	itemRe, text := bulletRe, 1
+	ordered, start := !bulletRe.MatchString(lines[i]), 1
+	if ordered {
+		itemRe, text = orderedRe, 2
+		start, _ = strconv.Atoi(orderedRe.FindStringSubmatch(lines[i])[1])
+	}
+
+	var items []string
+	for i < len(lines) && !ruleRe.MatchString(lines[i]) {
+		if m := itemRe.FindStringSubmatch(lines[i]); m != nil {
+			items = append(items, m[text])
+		} else if strings.HasPrefix(lines[i], " ") && strings.TrimSpace(lines[i]) != "" {
+			items[len(items)-1] += "\n" + strings.TrimSpace(lines[i])
+		} else {
+			break
+		}
+		i++
+	}
+
+	tag := "ul"
+	if ordered {
+		tag = "ol"
+	}
+	b.WriteString("<" + tag)
+	if start != 1 {
+		fmt.Fprintf(b, ` start="%d"`, start)
+	}
+	b.WriteString(">\n")
+	for _, item := range items {
+		fmt.Fprintf(b, "<li>%s</li>\n", renderInline(item))
+	}
+	b.WriteString("</" + tag + ">\n")
+	return i
+}
+
+func renderInline(s string) string {
+	var b strings.Builder
+	writeInline(&b, s)
+	return b.String()
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || '0' <= c && c <= '9' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+// writeInline renders the spans of a block: escapes, code, emphasis and links. Everything else is escaped text.
+func writeInline(b *strings.Builder, s string) {
+	for i := 0; i < len(s); {
+		switch c := s[i]; {
+		case c == '\\' && i+1 < len(s) && strings.IndexByte(escapable, s[i+1]) >= 0:
+			b.WriteString(html.EscapeString(s[i+1 : i+2]))
+			i += 2
+			continue
+
+		case c == '`':
+			n := len(s[i:]) - len(strings.TrimLeft(s[i:], "`"))
+			fence := s[i : i+n]
+			if end := strings.Index(s[i+n:], fence); end >= 0 {
+				b.WriteString("<code>")
+				b.WriteString(html.EscapeString(strings.TrimSpace(s[i+n : i+n+end])))
+				b.WriteString("</code>")
+				i += n + end + n
+			} else {
+				b.WriteString(fence) // unmatched, literal backticks
+				i += n
+			}
+			continue
+
+		case c == '*' || c == '_':
+			n := 1
+			if i+1 < len(s) && s[i+1] == c {
+				n = 2
+			}
+			delim := s[i : i+n]
+			// snake_case words aren't emphasis, and the delimiters must hug the text
+			opens := !(c == '_' && i > 0 && isWordByte(s[i-1])) && i+n < len(s) && s[i+n] != ' ' && s[i+n] != '\n'
+			if end := strings.Index(s[min(i+n, len(s)):], delim); opens && end > 0 && s[i+n+end-1] != ' ' {
+				tag := "em"
+				if n == 2 {
+					tag = "strong"
+				}
+				b.WriteString("<" + tag + ">")
+				writeInline(b, s[i+n:i+n+end])
+				b.WriteString("</" + tag + ">")
+				i += n + end + n
+			} else {
+				b.WriteString(delim)
+				i += n
+			}
+			continue
+
+		case c == '[':
+			if text, dest, n, ok := parseLink(s[i:]); ok {
+				if href, ok := safeURL(dest); ok {
+					fmt.Fprintf(b, `<a href="%s" rel="nofollow noopener">`, html.EscapeString(href))
+					writeInline(b, text)
+					b.WriteString("</a>")
+				} else {
+					writeInline(b, text) // unsafe target, keep the text only
+				}
+				i += n
+				continue
+			}
+		}
+
+		// plain text up to the next special character
+		next := len(s)
+		if j := strings.IndexAny(s[i+1:], "\\`*_["); j >= 0 {
+			next = i + 1 + j
+		}
+		b.WriteString(html.EscapeString(s[i:next]))
+		i = next
+	}
+}
+
+// parseLink parses [text](dest) at the start of s and returns its parts and length.
+func parseLink(s string) (text, dest string, n int, ok bool) {
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '[':
+			depth++
+		case ']':
+			if depth--; depth > 0 {
+				continue
+			}
+			if i+1 >= len(s) || s[i+1] != '(' {
+				return "", "", 0, false
+			}
+			end := closingParen(s[i+2:])
+			if end < 0 {
+				return "", "", 0, false
+			}
+			dest = strings.TrimSpace(s[i+2 : i+2+end])
+			if dest == "" || strings.ContainsAny(dest, " \t\n") {
+				return "", "", 0, false
+			}
+			return s[1:i], dest, i + 3 + end, true
+		}
+	}
+	return "", "", 0, false
+}
+
+// closingParen returns the index of the ")" closing a link destination, which may hold balanced parentheses.
+func closingParen(s string) int {
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '(':
+			depth++
+		case ')':
+			if depth == 0 {
+				return i
+			}
+			depth--
+		}
+	}
+	return -1
+}
+
+// safeURL accepts http, https, mailto and relative URLs, javascript: and friends are refused.
+func safeURL(raw string) (string, bool) {
+	// Parse refuses control characters and lower-cases the scheme, "JaVaScRiPt:" can't sneak through
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", false
+	}
+	switch u.Scheme {
+	case "http", "https", "mailto", "":
+		return u.String(), true
+	default:
+		return "", false
+	}
+}
+
+// BeforeSave renders Content into ContentHTML, on create and whenever an update writes Content.
+func (p *Post) BeforeSave(tx *gorm.DB) error {
+	stmt := tx.Statement
+	// with a field mask, e.g. Select("Content").Updates(p), the HTML is only rendered and written along with the content
+	if selected, restricted := stmt.SelectAndOmitColumns(false, true); restricted {
+		if !selected["content"] {
+			return nil
+		}
+		stmt.Selects = append(stmt.Selects, "content_html")
+	}
+
+	content := p.Content
+	// Create and Save write the model itself, Update and Updates write their own values
+	if stmt.Dest != stmt.Model {
+		if !stmt.Changed("Content") {
+			return nil
+		}
+		var ok bool
+		if content, ok = updatedContent(stmt.Dest); !ok {
+			return nil // e.g. a gorm.Expr, RenderPostsHTML catches up
+		}
+	}
+
+	stmt.SetColumn("ContentHTML", RenderMarkdown(content))
+	return nil
+}
+
+// updatedContent returns the Content written by Update/Updates.
+func updatedContent(dest any) (string, bool) {
+	if m, ok := dest.(map[string]any); ok {
+		for _, key := range []string{"Content", "content"} {
+			if v, ok := m[key]; ok {
+				s, ok := v.(string)
+				return s, ok
+			}
+		}
+		return "", false
+	}
+
+	v := reflect.Indirect(reflect.ValueOf(dest))
+	if v.Kind() != reflect.Struct {
+		return "", false
+	}
+	f := v.FieldByName("Content")
+	if !f.IsValid() || f.Kind() != reflect.String {
+		return "", false
+	}
+	return f.String(), true
+}
+
+const renderBatchSize = 500
+
+// RenderPostsHTML re-renders the cached HTML of existing posts, deleted ones included, and returns how many
+// changed. Without all, only posts with an empty cache are rendered (e.g. created before the column existed),
+// with all every post is, e.g. after a change of the renderer.
+func RenderPostsHTML(db *gorm.DB, all bool) (int, error) {
+	query := db.Unscoped().Select("id", "content", "content_html")
+	if !all {
+		query = query.Where("content_html = '' OR content_html IS NULL")
+	}
+
+	rendered := 0
+	var posts []Post
+	err := query.FindInBatches(&posts, renderBatchSize, func(tx *gorm.DB, _ int) error {
+		for _, p := range posts {
+			out := RenderMarkdown(p.Content)
+			if out == p.ContentHTML {
+				continue
+			}
+			// UpdateColumn skips the hooks, the text didn't change and needs no new revision
+			if err := db.Unscoped().Model(&Post{}).
+				Where("id = ?", p.ID).
+				UpdateColumn("content_html", out).Error; err != nil {
+				return err
+			}
+			rendered++
+		}
+		return nil
+	}).Error
+	return rendered, err
+}
+
+func MarkdownTest(db *gorm.DB) {
+	p, err := CreatePost(db, 1, "Markdown", "# Hello\n\nSome **bold** text, [a link](https://gorm.io) and "+
+		"[a trap](javascript:alert(1)).\n\n<script>alert(1)</script>")
+	if err != nil {
+		panic(err)
+	}
+	fmt.Print(p.ContentHTML)
+
+	if _, err := UpdatePost(db, p.ID, p.Subject, "- one\n- two"); err != nil {
+		panic(err)
+	}
+	var updated Post
+	if err := db.Select("content_html").First(&updated, p.ID).Error; err != nil {
+		panic(err)
+	}
+	fmt.Print(updated.ContentHTML)
+
+	n, err := RenderPostsHTML(db, false)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println("re-rendered posts:", n)
+}
@@ -11,28 +11,84 @@ type User struct {
 	Name  string `gorm:"size:64;not null"`
 	Email string `gorm:"size:64;uniqueIndex;not null"`
 	Posts []Post
+
+	Moderator bool `gorm:"not null;default:false"` // may approve and reject comments, see moderation.go
 }
 
 type Post struct {
 	ID        uint   `gorm:"primaryKey"`
 	Subject   string `gorm:"size:255;not null"`
-	Content   string `gorm:"not null"`
+	Content   string `gorm:"not null"`                          // Markdown, see markdown.go
 	UserID    uint   `gorm:"index:idx_user_created,priority:1"` // FK
 	Tags      []Tag  `gorm:"many2many:post_tags"`
 	Comments  []Comment
 	CreatedAt time.Time `gorm:"autoCreateAt;index:idx_user_created,priority:2"`
+	UpdatedAt time.Time // bumped by every Update/Updates, the <updated> of the feeds, see syndication.go
+
+	Status      PostStatus `gorm:"size:16;not null;default:published;index"` // see lifecycle.go
+	ScheduledAt *time.Time // when a scheduled post goes live
+	PublishedAt *time.Time `gorm:"index"`
+
+	ReactionCount int64 `gorm:"not null;default:0"` // cached COUNT of the post's reactions, see reactions.go
+
+	ContentHTML string `gorm:"not null;default:''" json:"-"` // Content rendered by the BeforeSave hook, see markdown.go
+
+	DeletedAt gorm.DeletedAt `gorm:"index"` // soft delete, cascades to the comments, see cascade.go
 }
 
 type Tag struct {
 	ID    uint   `gorm:"primaryKey"`
 	Name  string `gorm:"size:64;uniqueIndex;not null"`
+	Slug  string `gorm:"size:64;uniqueIndex"` // URL-safe, derived from Name, see tags.go
 	Posts []Post `gorm:"many2many:post_tags"`
 }
 
 type Comment struct {
 	ID        uint           `gorm:"primaryKey"`
-	PostID    uint           `gorm:"index"` // FK
+	PostID    uint           `gorm:"index"`              // FK
+	ParentID  *uint          `gorm:"index"`              // FK to the comment replied to, NULL for top-level comments
+	Depth     int            `gorm:"not null;default:0"` // 0 for top-level comments, parent's depth + 1 for replies
 	Content   string         `gorm:"not null"`
 	UserID    uint           `gorm:"index"` // FK
+	CreatedAt time.Time      `gorm:"autoCreateTime"`
 	DeletedAt gorm.DeletedAt `gorm:"index"`
+
+	Status           CommentStatus `gorm:"size:16;not null;default:approved;index"` // see moderation.go
+	ModerationReason string        `gorm:"size:255"`
+	ModeratedBy      *uint         // FK to the moderator, NULL for automatic decisions
+	ModeratedAt      *time.Time
+
+	DeletedWithPost bool `gorm:"not null;default:false"` // deleted by the cascade of DeletePost, undone by RestorePost
+}
+
+// Migrate creates or updates every table used by the blog.
+func Migrate(db *gorm.DB) error {
+	if err := db.AutoMigrate(&User{}, &Post{}, &Tag{}, &Comment{}, &PostRevision{}, &Reaction{}, &Follow{}, &FeedItem{},
+		&PostView{}, &PostViewHourly{}, &PostViewViewer{}, &Notification{}, &RateLimitCounter{}); err != nil {
+		return err
+	}
+
+	// posts created before the lifecycle existed were live from the start
+	if err := db.Exec(
+		"UPDATE posts SET published_at = created_at WHERE status = ? AND published_at IS NULL", PostPublished,
+	).Error; err != nil {
+		return err
+	}
+
+	// and unchanged since
+	if err := db.Exec(
+		"UPDATE posts SET updated_at = COALESCE(published_at, created_at) WHERE updated_at IS NULL",
+	).Error; err != nil {
+		return err
+	}
+
+	if err := backfillTagSlugs(db); err != nil {
+		return err
+	}
+
+	if err := backfillRevisions(db); err != nil {
+		return err
+	}
+
+	return migrateSearch(db)
 }
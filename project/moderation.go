@@ -0,0 +1,345 @@
+// Comment moderation
+//
+// A new comment goes through the Moderation policy of the Config (see WithConfig) before it is inserted:
+//
+// +---------+   filters    +----------+   ApproveComment   +----------+
+// | new     | -----------> | pending  | -----------------> | approved |
+// +---------+              +----------+                    +----------+
+//      |                        |  RejectComment                |  ^
+//      |                        v                               v  |
+//      +------- filters ---> +----------+ <--- RejectComment ---+  |
+//                            | rejected | ----- ApproveComment ----+
+//                            +----------+
+//
+// Public reads only ever see approved comments. Like gorm.DeletedAt, CommentStatus adds its condition
+// to every query on comments, including Preload("Comments"):
+//
+// SELECT * FROM comments WHERE comments.post_id = 1 AND comments.status = 'approved' AND comments.deleted_at IS NULL;
+//
+// Moderators opt out with IncludeUnapproved(ctx), Unscoped() drops both conditions.
+//
+// Only users with the Moderator flag (see SetModerator) can moderate, and never their own comments,
+// otherwise anyone could approve what the filters held back.
+
+package project
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+type CommentStatus string
+
+const (
+	CommentPending  CommentStatus = "pending"
+	CommentApproved CommentStatus = "approved"
+	CommentRejected CommentStatus = "rejected"
+)
+
+var ErrAlreadyModerated = errors.New("comment already moderated")
+
+// RemovedCommentContent replaces the content of unapproved comments that still have visible replies.
+const RemovedCommentContent = "[removed]"
+
+// severity of a verdict, the strictest filter wins
+var severity = map[CommentStatus]int{
+	CommentApproved: 0,
+	CommentPending:  1,
+	CommentRejected: 2,
+}
+
+// A CommentFilter inspects a new comment. It returns CommentApproved to let it through,
+// CommentPending to hold it for a moderator or CommentRejected to reject it, with a reason.
+type CommentFilter interface {
+	Check(c *Comment) (CommentStatus, string)
+}
+
+// CommentFilterFunc adapts a function to CommentFilter.
+type CommentFilterFunc func(c *Comment) (CommentStatus, string)
+
+func (f CommentFilterFunc) Check(c *Comment) (CommentStatus, string) {
+	return f(c)
+}
+
+// BlockedWords rejects comments containing any of Words, case-insensitively and as whole words.
+type BlockedWords struct {
+	Words []string
+}
+
+func (b BlockedWords) Check(c *Comment) (CommentStatus, string) {
+	for _, w := range strings.FieldsFunc(strings.ToLower(c.Content), isWordSeparator) {
+		for _, blocked := range b.Words {
+			if w == strings.ToLower(blocked) {
+				return CommentRejected, fmt.Sprintf("blocked word %q", blocked)
+			}
+		}
+	}
+	return CommentApproved, ""
+}
+
+func isWordSeparator(r rune) bool {
+	return !(r == '\'' || r == '-' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r > 127)
+}
+
+var linkPattern = regexp.MustCompile(`(?i)https?://|www\.`)
+
+// LinkSpam holds comments with more than MaxLinks links for review.
+type LinkSpam struct {
+	MaxLinks int
+}
+
+func (l LinkSpam) Check(c *Comment) (CommentStatus, string) {
+	if n := len(linkPattern.FindAllStringIndex(c.Content, -1)); n > l.MaxLinks {
+		return CommentPending, fmt.Sprintf("%d links", n)
+	}
+	return CommentApproved, ""
+}
+
+// ModerationPolicy is applied to every comment created without an explicit Status.
+// The zero policy approves everything.
+type ModerationPolicy struct {
+	RequireApproval bool // hold every comment that passes the filters
+	Filters         []CommentFilter
+}
+
+// Review runs the filters and returns the resulting status with the reason of the strictest filter.
+func (p ModerationPolicy) Review(c *Comment) (CommentStatus, string) {
+	status, reason := CommentApproved, ""
+	for _, f := range p.Filters {
+		s, r := f.Check(c)
+		if severity[s] > severity[status] {
+			status, reason = s, r
+		}
+	}
+
+	if status == CommentApproved && p.RequireApproval {
+		return CommentPending, "awaiting review"
+	}
+	return status, reason
+}
+
+func (c *Comment) BeforeCreate(tx *gorm.DB) error {
+	if c.Status != "" {
+		return nil // decided by the caller, e.g. an import
+	}
+
+	c.Status, c.ModerationReason = configFrom(tx.Statement.Context).Moderation.Review(c)
+	if c.ModerationReason != "" {
+		now := tx.NowFunc()
+		c.ModeratedAt = &now
+	}
+	return nil
+}
+
+type ctxKeyIncludeUnapproved struct{}
+
+// IncludeUnapproved lets queries run with ctx see pending and rejected comments too.
+func IncludeUnapproved(ctx context.Context) context.Context {
+	return context.WithValue(ctx, ctxKeyIncludeUnapproved{}, true)
+}
+
+func includesUnapproved(ctx context.Context) bool {
+	if ctx == nil {
+		return false
+	}
+	all, _ := ctx.Value(ctxKeyIncludeUnapproved{}).(bool)
+	return all
+}
+
+func (CommentStatus) QueryClauses(f *schema.Field) []clause.Interface {
+	return []clause.Interface{approvedCommentsClause{Field: f}}
+}
+
+// approvedCommentsClause is a query clause that keeps approved comments only, see gorm.SoftDeleteQueryClause.
+type approvedCommentsClause struct {
+	Field *schema.Field
+}
+
+func (approvedCommentsClause) Name() string {
+	return ""
+}
+
+func (approvedCommentsClause) Build(clause.Builder) {
+}
+
+func (approvedCommentsClause) MergeClause(*clause.Clause) {
+}
+
+func (ac approvedCommentsClause) ModifyStatement(stmt *gorm.Statement) {
+	if _, ok := stmt.Clauses["approved_comments_enabled"]; ok || stmt.Unscoped || includesUnapproved(stmt.Context) {
+		return
+	}
+
+	// a lone OR condition would otherwise swallow ours: WHERE a OR b AND status = ...
+	if c, ok := stmt.Clauses["WHERE"]; ok {
+		if where, ok := c.Expression.(clause.Where); ok && len(where.Exprs) >= 1 {
+			for _, expr := range where.Exprs {
+				if orCond, ok := expr.(clause.OrConditions); ok && len(orCond.Exprs) == 1 {
+					where.Exprs = []clause.Expression{clause.And(where.Exprs...)}
+					c.Expression = where
+					stmt.Clauses["WHERE"] = c
+					break
+				}
+			}
+		}
+	}
+
+	stmt.AddClause(clause.Where{Exprs: []clause.Expression{
+		clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: ac.Field.DBName}, Value: CommentApproved},
+	}})
+	stmt.Clauses["approved_comments_enabled"] = clause.Clause{}
+}
+
+// SetModerator grants or revokes the moderator role of a user.
+func SetModerator(db *gorm.DB, userID uint, moderator bool) error {
+	result := db.Model(&User{}).Where("id = ?", userID).Update("moderator", moderator)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// requireModerator returns ErrForbidden unless userID is a moderator.
+func requireModerator(db *gorm.DB, userID uint) error {
+	var u User
+	if err := db.Select("id", "moderator").First(&u, userID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("%w: unknown user %d", ErrForbidden, userID)
+		}
+		return err
+	}
+	if !u.Moderator {
+		return fmt.Errorf("%w: user %d is not a moderator", ErrForbidden, userID)
+	}
+	return nil
+}
+
+// moderateComment records the decision of a moderator if the comment is in one of the from states.
+func moderateComment(db *gorm.DB, commentID, moderatorID uint, from []CommentStatus, to CommentStatus, reason string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		tx = tx.WithContext(IncludeUnapproved(tx.Statement.Context))
+
+		if err := requireModerator(tx, moderatorID); err != nil {
+			return err // roll back
+		}
+		var c Comment
+		if err := tx.Select("id", "user_id").First(&c, commentID).Error; err != nil {
+			return err // roll back
+		}
+		if c.UserID == moderatorID {
+			return fmt.Errorf("%w: moderators can't moderate their own comments", ErrForbidden) // roll back
+		}
+
+		result := tx.Model(&Comment{}).
+			Where("id = ? AND status IN ?", commentID, from).
+			Updates(map[string]any{
+				"status":            to,
+				"moderation_reason": reason,
+				"moderated_by":      moderatorID,
+				"moderated_at":      tx.NowFunc(),
+			})
+		if result.Error != nil {
+			return result.Error // roll back
+		}
+		if result.RowsAffected > 0 {
+			if to == CommentApproved {
+				return onCommentApproved(tx, commentID)
+			}
+			return nil
+		}
+
+		if err := tx.Select("id", "status").First(&c, commentID).Error; err != nil {
+			return err // roll back
+		}
+		return fmt.Errorf("%w: comment is %s", ErrAlreadyModerated, c.Status)
+	})
+}
+
+// onCommentApproved is called by AddComment, ReplyToComment and moderateComment once the comment is approved,
+// so the post author hears of a held comment only when readers can see it.
+func onCommentApproved(tx *gorm.DB, commentID uint) error {
+	return notifyCommentCreated(tx, commentID)
+}
+
+// ApproveComment publishes a pending or previously rejected comment.
+func ApproveComment(db *gorm.DB, commentID, moderatorID uint) error {
+	return moderateComment(db, commentID, moderatorID, []CommentStatus{CommentPending, CommentRejected}, CommentApproved, "")
+}
+
+// RejectComment hides a pending or approved comment, reason is shown to the moderators.
+func RejectComment(db *gorm.DB, commentID, moderatorID uint, reason string) error {
+	if strings.TrimSpace(reason) == "" {
+		return fmt.Errorf("%w: reason is required", ErrInvalidInput)
+	}
+	return moderateComment(db, commentID, moderatorID, []CommentStatus{CommentPending, CommentApproved}, CommentRejected, reason)
+}
+
+// ModerationQueue returns the pending comments, oldest first, to moderatorID.
+func ModerationQueue(db *gorm.DB, moderatorID uint, number int) ([]Comment, error) {
+	if err := requireModerator(db, moderatorID); err != nil {
+		return nil, err
+	}
+
+	var comments []Comment
+	if err := db.WithContext(IncludeUnapproved(db.Statement.Context)).
+		Where("status = ?", CommentPending).
+		Order("created_at, id").
+		Limit(number).
+		Find(&comments).Error; err != nil {
+		return nil, err
+	}
+	return comments, nil
+}
+
+func CommentModerationTest(db *gorm.DB) {
+	cfg := configFrom(db.Statement.Context)
+	cfg.Moderation = ModerationPolicy{
+		Filters: []CommentFilter{
+			BlockedWords{Words: []string{"scam"}},
+			LinkSpam{MaxLinks: 1},
+		},
+	}
+	db = db.WithContext(WithConfig(db.Statement.Context, cfg))
+
+	spam, err := AddComment(db, 2, 3, "Total scam, click here")
+	if err != nil {
+		panic(err)
+	}
+	held, err := AddComment(db, 2, 3, "See http://a.example and http://b.example")
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("comment %d: %s (%s), comment %d: %s (%s)\n",
+		spam.ID, spam.Status, spam.ModerationReason, held.ID, held.Status, held.ModerationReason)
+
+	// public reads don't see the held comment until a moderator approves it
+	var p Post
+	if err := db.Preload("Comments").First(&p, 2).Error; err != nil {
+		panic(err)
+	}
+	fmt.Println("public comments before approval:", len(p.Comments))
+
+	if err := ApproveComment(db, held.ID, 1); err != nil {
+		panic(err)
+	}
+	if err := db.Preload("Comments").First(&p, 2).Error; err != nil {
+		panic(err)
+	}
+	fmt.Println("public comments after approval:", len(p.Comments))
+
+	queue, err := ModerationQueue(db, 1, 10)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println("comments waiting for review:", len(queue))
+}
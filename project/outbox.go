@@ -0,0 +1,250 @@
+// Notification outbox
+//
+// Notifications are written to the notifications table in the transaction of the write that causes them,
+// so they commit or roll back with it, and delivered afterwards by a Dispatcher:
+//
+// | kind            | recipient_id | post_id | comment_id | attempts | next_attempt_at     | sent_at             |
+// | --------------- | ------------ | ------- | ---------- | -------- | ------------------- | ------------------- |
+// | post_published  | 2            | 7       | 0          | 1        | 2024-01-01 10:00:00 | 2024-01-01 10:00:01 |
+// | post_published  | 3            | 7       | 0          | 2        | 2024-01-01 10:00:04 | NULL                |
+// | comment_created | 1            | 7       | 12         | 0        | 2024-01-01 10:05:00 | NULL                |
+//
+// - a post becoming published (onPublished) notifies the followers of its author
+// - a comment becoming visible, on creation or when a moderator approves it (onCommentApproved), notifies the
+//   author of the post, unless they wrote the comment
+//
+// (kind, recipient_id, post_id, comment_id) is unique: republishing a post or approving a comment again
+// doesn't notify twice.
+//
+// Delivery is at least once: a dispatcher stopping between the delivery and marking it sent delivers again
+// after the lease, Notification.ID can serve as an idempotency key. Failed deliveries are retried with an
+// exponential backoff, after MaxAttempts the notification stays unsent with its LastError.
+
+package project
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type NotificationKind string
+
+const (
+	NotifyPostPublished  NotificationKind = "post_published"
+	NotifyCommentCreated NotificationKind = "comment_created"
+)
+
+type Notification struct {
+	ID          uint             `gorm:"primaryKey"`
+	Kind        NotificationKind `gorm:"size:32;not null;uniqueIndex:idx_notification_event,priority:1"`
+	RecipientID uint             `gorm:"not null;uniqueIndex:idx_notification_event,priority:2"`           // FK to the notified user
+	PostID      uint             `gorm:"not null;uniqueIndex:idx_notification_event,priority:3"`           // FK
+	CommentID   uint             `gorm:"not null;default:0;uniqueIndex:idx_notification_event,priority:4"` // FK, 0 when not about a comment
+	ActorID     uint             `gorm:"not null"`                                                         // FK to the user who caused it
+	CreatedAt   time.Time        `gorm:"autoCreateTime"`
+
+	// delivery state, see Dispatcher
+	Attempts      int        `gorm:"not null;default:0"`
+	NextAttemptAt time.Time  `gorm:"not null;index"`
+	SentAt        *time.Time `gorm:"index"`
+	LastError     string     `gorm:"size:255"`
+}
+
+// notifyPostPublished queues a notification for every follower of the author of a just published post.
+func notifyPostPublished(tx *gorm.DB, postID uint) error {
+	var p Post
+	if err := tx.Select("id", "user_id").First(&p, postID).Error; err != nil {
+		return err
+	}
+
+	now := tx.NowFunc()
+	return tx.Exec(`
+		INSERT INTO notifications (kind, recipient_id, post_id, comment_id, actor_id, created_at, attempts, next_attempt_at)
+		SELECT ?, follower_id, ?, 0, ?, ?, 0, ? FROM follows WHERE followee_id = ?
+		ON CONFLICT (kind, recipient_id, post_id, comment_id) DO NOTHING
+	`, NotifyPostPublished, p.ID, p.UserID, now, now, p.UserID).Error
+}
+
+// notifyCommentCreated queues a notification for the author of the post of a comment that just became visible.
+func notifyCommentCreated(tx *gorm.DB, commentID uint) error {
+	var c Comment
+	if err := tx.Unscoped().Select("id", "post_id", "user_id").First(&c, commentID).Error; err != nil {
+		return err
+	}
+	var p Post
+	if err := tx.Unscoped().Select("id", "user_id").First(&p, c.PostID).Error; err != nil {
+		return err
+	}
+	if p.UserID == c.UserID {
+		return nil // commenting on your own post
+	}
+
+	return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&Notification{
+		Kind:          NotifyCommentCreated,
+		RecipientID:   p.UserID,
+		PostID:        p.ID,
+		CommentID:     c.ID,
+		ActorID:       c.UserID,
+		NextAttemptAt: tx.NowFunc(),
+	}).Error
+}
+
+// Notifier delivers a notification, e.g. by email or push. A returned error schedules a retry.
+type Notifier interface {
+	Notify(ctx context.Context, n Notification) error
+}
+
+type NotifierFunc func(ctx context.Context, n Notification) error
+
+func (f NotifierFunc) Notify(ctx context.Context, n Notification) error {
+	return f(ctx, n)
+}
+
+// Dispatcher delivers the queued notifications.
+type Dispatcher struct {
+	db       *gorm.DB
+	notifier Notifier
+	interval time.Duration
+
+	BatchSize   int                             // notifications claimed per round, 100 by default
+	MaxAttempts int                             // deliveries tried before giving up, 8 by default
+	Lease       time.Duration                   // how long a claimed notification is left to its dispatcher, 1 minute by default
+	Backoff     func(attempt int) time.Duration // delay before retrying after the attempt-th failure
+}
+
+func NewDispatcher(db *gorm.DB, notifier Notifier, interval time.Duration) *Dispatcher {
+	return &Dispatcher{
+		db:          db,
+		notifier:    notifier,
+		interval:    interval,
+		BatchSize:   100,
+		MaxAttempts: 8,
+		Lease:       time.Minute,
+		Backoff: func(attempt int) time.Duration {
+			// 1s, 2s, 4s, ... at most an hour
+			return min(time.Second<<(attempt-1), time.Hour)
+		},
+	}
+}
+
+// Run delivers due notifications every interval until ctx is done.
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := d.DispatchDue(ctx); err != nil {
+			d.db.Logger.Error(ctx, "failed to dispatch notifications, %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// DispatchDue delivers the notifications whose next attempt is due and returns how many were sent.
+// Delivery failures are recorded on the notifications for a later retry, only database errors are returned.
+func (d *Dispatcher) DispatchDue(ctx context.Context) (int, error) {
+	db := d.db.WithContext(ctx)
+	now := db.NowFunc()
+
+	var due []Notification
+	if err := db.
+		Where("sent_at IS NULL AND attempts < ? AND next_attempt_at <= ?", d.MaxAttempts, now).
+		Order("next_attempt_at, id").
+		Limit(d.BatchSize).
+		Find(&due).Error; err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	var errs []error
+	for _, n := range due {
+		// claim: counting the attempt and moving the next one past the lease,
+		// a concurrent dispatcher that read the same row updates nothing and skips it
+		attempt := n.Attempts + 1
+		result := db.Model(&Notification{}).
+			Where("id = ? AND sent_at IS NULL AND attempts = ?", n.ID, n.Attempts).
+			Updates(map[string]any{"attempts": attempt, "next_attempt_at": now.Add(d.Lease)})
+		if result.Error != nil {
+			errs = append(errs, fmt.Errorf("notification %d: %w", n.ID, result.Error))
+			continue
+		}
+		if result.RowsAffected == 0 {
+			continue
+		}
+		n.Attempts = attempt
+
+		updates := map[string]any{"sent_at": db.NowFunc(), "last_error": ""}
+		if err := d.notifier.Notify(ctx, n); err != nil {
+			msg := err.Error()
+			if r := []rune(msg); len(r) > 255 {
+				msg = string(r[:255])
+			}
+			updates = map[string]any{"next_attempt_at": db.NowFunc().Add(d.Backoff(attempt)), "last_error": msg}
+		}
+		if err := db.Model(&Notification{}).
+			Where("id = ? AND attempts = ?", n.ID, attempt).
+			Updates(updates).Error; err != nil {
+			errs = append(errs, fmt.Errorf("notification %d: %w", n.ID, err))
+			continue
+		}
+		if _, ok := updates["sent_at"]; ok {
+			sent++
+		}
+	}
+	return sent, errors.Join(errs...)
+}
+
+func OutboxTest(db *gorm.DB) {
+	if err := FollowUser(db, 3, 1); err != nil {
+		panic(err)
+	}
+
+	// a rolled back publication leaves no notification behind
+	errRollback := errors.New("roll back")
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if _, err := PublishPostWithTags(tx, 1, "Never published", "Rolled back", nil); err != nil {
+			return err
+		}
+		return errRollback
+	})
+	if !errors.Is(err, errRollback) {
+		panic(err)
+	}
+
+	p, err := PublishPostWithTags(db, 1, "Outbox", "Followers are notified once this commits", nil)
+	if err != nil {
+		panic(err)
+	}
+	if _, err := AddComment(db, p.ID, 2, "Notifies the author"); err != nil {
+		panic(err)
+	}
+
+	failures := 1
+	d := NewDispatcher(db, NotifierFunc(func(ctx context.Context, n Notification) error {
+		if failures > 0 {
+			failures--
+			return errors.New("mail server unavailable")
+		}
+		fmt.Printf("notify user %d: %s on post %d\n", n.RecipientID, n.Kind, n.PostID)
+		return nil
+	}), time.Second)
+	d.Backoff = func(int) time.Duration { return 0 }
+
+	for round := 1; round <= 2; round++ {
+		sent, err := d.DispatchDue(context.Background())
+		if err != nil {
+			panic(err)
+		}
+		fmt.Printf("round %d: sent %d\n", round, sent)
+	}
+}
@@ -0,0 +1,138 @@
+// Rate limiting
+//
+// Users get a quota of comments and posts per sliding window, e.g. at most 5 comments per minute.
+// Every write counts itself in rate_limit_counters, in the transaction of the write, one row per user,
+// action and slot (a 60th of the window):
+//
+// | user_id | action  | slot_start          | count |
+// | ------- | ------- | ------------------- | ----- |
+// | 2       | comment | 2024-01-01 10:00:01 | 2     |
+// | 2       | comment | 2024-01-01 10:00:17 | 1     |
+// | 2       | post    | 2024-01-01 00:24:00 | 1     |
+//
+// The write is allowed while the slots overlapping the last window sum up to at most the limit,
+// otherwise it fails with a *RateLimitError and its transaction rolls back, counter included:
+//
+// INSERT INTO rate_limit_counters ... ON CONFLICT DO UPDATE SET count = count + 1;
+// SELECT slot_start, count FROM rate_limit_counters WHERE user_id = 2 AND action = 'comment' AND slot_start > ...;
+//
+// The counter is incremented before it is read: the INSERT takes SQLite's write lock, so concurrent
+// transactions of the same user count one after the other and can't both see the last free unit.
+// A transaction that read before another one wrote fails with "database is locked" rather than slipping past.
+//
+// Slots are at most a 60th of the window late to expire, a user waits that much longer at worst.
+//
+// The limits are Config.PostRateLimit and Config.CommentRateLimit, see config.go for the defaults.
+
+package project
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type RateLimitCounter struct {
+	UserID    uint      `gorm:"primaryKey"`         // FK
+	Action    string    `gorm:"primaryKey;size:32"` // RateLimit.Action
+	SlotStart time.Time `gorm:"primaryKey;index"`   // UTC
+	Count     int       `gorm:"not null;default:0"`
+}
+
+// RateLimit allows at most Limit actions of a user per Window. A zero Limit disables it.
+type RateLimit struct {
+	Limit  int
+	Window time.Duration
+}
+
+// actions counted in rate_limit_counters
+const (
+	actionPost    = "post"
+	actionComment = "comment"
+)
+
+// RateLimitError is returned when a user exceeded a RateLimit.
+type RateLimitError struct {
+	RateLimit
+	Action     string        // "post" or "comment"
+	RetryAfter time.Duration // until the oldest counted actions leave the window
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("rate limit exceeded: at most %d %ss per %s, retry after %s",
+		e.Limit, e.Action, e.Window, e.RetryAfter.Round(time.Second))
+}
+
+func (l RateLimit) slot() time.Duration {
+	return max(l.Window/60, time.Second)
+}
+
+// allow counts an action of userID and returns a *RateLimitError if it goes over the limit.
+// It must run in the transaction of the write, which must roll back on error.
+func (l RateLimit) allow(tx *gorm.DB, action string, userID uint) error {
+	if l.Limit <= 0 {
+		return nil
+	}
+
+	now := tx.NowFunc().UTC()
+	slot := l.slot()
+	// slots ending before the window starts have expired
+	since := now.Add(-l.Window - slot)
+
+	if err := tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "action"}, {Name: "slot_start"}},
+		DoUpdates: clause.Assignments(map[string]any{"count": gorm.Expr("rate_limit_counters.count + 1")}),
+	}).Create(&RateLimitCounter{UserID: userID, Action: action, SlotStart: now.Truncate(slot), Count: 1}).Error; err != nil {
+		return err
+	}
+
+	var counters []RateLimitCounter
+	if err := tx.
+		Where("user_id = ? AND action = ? AND slot_start > ?", userID, action, since).
+		Order("slot_start").
+		Find(&counters).Error; err != nil {
+		return err
+	}
+	total := 0
+	for _, c := range counters {
+		total += c.Count
+	}
+	if total <= l.Limit {
+		// housekeeping, only for the allowed actions as the others roll back
+		return tx.Where("user_id = ? AND action = ? AND slot_start <= ?", userID, action, since).
+			Delete(&RateLimitCounter{}).Error
+	}
+
+	// wait for the oldest slots to expire until this action fits, the action itself isn't counted
+	excess := total - l.Limit
+	for _, c := range counters {
+		excess -= c.Count
+		if excess <= 0 {
+			retry := c.SlotStart.Add(slot + l.Window).Sub(now)
+			return &RateLimitError{RateLimit: l, Action: action, RetryAfter: max(retry, time.Second)}
+		}
+	}
+	return &RateLimitError{RateLimit: l, Action: action, RetryAfter: l.Window} // unreachable, the current slot counts
+}
+
+func RateLimitTest(db *gorm.DB) {
+	cfg := configFrom(db.Statement.Context)
+	cfg.CommentRateLimit = RateLimit{Limit: 3, Window: time.Minute}
+	db = db.WithContext(WithConfig(db.Statement.Context, cfg))
+
+	for i := 1; i <= 4; i++ {
+		_, err := AddComment(db, 1, 3, fmt.Sprintf("Comment %d within a minute", i))
+		var rl *RateLimitError
+		switch {
+		case err == nil:
+			fmt.Printf("comment %d: accepted\n", i)
+		case errors.As(err, &rl):
+			fmt.Printf("comment %d: %v\n", i, rl)
+		default:
+			panic(err)
+		}
+	}
+}
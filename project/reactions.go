@@ -0,0 +1,229 @@
+// Reactions
+//
+// A user reacts at most once to a post or a comment, (user_id, target_type, target_id) is unique.
+// Reacting again changes the kind of the existing reaction.
+//
+// Post.ReactionCount caches the number of reactions to a post. It is only ever changed with atomic
+// conditional updates (see advanced/optimistic_pessimistic_lock.go), in the transaction that inserts or
+// deletes the reaction, so concurrent reactions can't lose increments:
+//
+// INSERT INTO reactions ... ON CONFLICT (user_id, target_type, target_id) DO NOTHING;  -- 1 row: new reaction
+// UPDATE posts SET reaction_count = reaction_count + 1 WHERE id = ?;
+//
+// DELETE FROM reactions WHERE user_id = ? AND target_type = 'post' AND target_id = ?; -- 1 row: removed
+// UPDATE posts SET reaction_count = reaction_count - 1 WHERE id = ? AND reaction_count > 0;
+//
+// Counters can still drift when rows are changed behind the application's back,
+// ReconcileReactionCounts recomputes them from the reactions table.
+
+package project
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type ReactionKind string
+
+const (
+	ReactionLike  ReactionKind = "like"
+	ReactionLove  ReactionKind = "love"
+	ReactionLaugh ReactionKind = "laugh"
+	ReactionWow   ReactionKind = "wow"
+	ReactionSad   ReactionKind = "sad"
+	ReactionAngry ReactionKind = "angry"
+)
+
+var reactionKinds = map[ReactionKind]bool{
+	ReactionLike:  true,
+	ReactionLove:  true,
+	ReactionLaugh: true,
+	ReactionWow:   true,
+	ReactionSad:   true,
+	ReactionAngry: true,
+}
+
+type ReactionTarget string
+
+const (
+	ReactionOnPost    ReactionTarget = "post"
+	ReactionOnComment ReactionTarget = "comment"
+)
+
+type Reaction struct {
+	ID         uint           `gorm:"primaryKey"`
+	UserID     uint           `gorm:"not null;uniqueIndex:idx_reaction_user_target,priority:1"` // FK
+	TargetType ReactionTarget `gorm:"size:16;not null;uniqueIndex:idx_reaction_user_target,priority:2;index:idx_reaction_target,priority:1"`
+	TargetID   uint           `gorm:"not null;uniqueIndex:idx_reaction_user_target,priority:3;index:idx_reaction_target,priority:2"`
+	Kind       ReactionKind   `gorm:"size:16;not null"`
+	CreatedAt  time.Time      `gorm:"autoCreateTime"`
+}
+
+// CounterDrift reports a post whose cached ReactionCount didn't match its reactions.
+type CounterDrift struct {
+	PostID uint
+	Stored int64
+	Actual int64
+	Fixed  bool // false when the counter moved while reconciling, the next run picks it up again
+}
+
+// reactionTarget makes sure the target exists and is visible to the reacting user.
+func reactionTarget(tx *gorm.DB, target ReactionTarget, targetID uint) error {
+	switch target {
+	case ReactionOnPost:
+		return tx.Scopes(visiblePosts).Select("id").First(&Post{}, targetID).Error
+	case ReactionOnComment:
+		// approved comments only, see moderation.go
+		return tx.Select("id").First(&Comment{}, targetID).Error
+	default:
+		return fmt.Errorf("%w: unknown reaction target %q", ErrInvalidInput, target)
+	}
+}
+
+// React records the reaction of userID to a post or a comment, replacing their previous reaction to it.
+func React(db *gorm.DB, userID uint, target ReactionTarget, targetID uint, kind ReactionKind) error {
+	if !reactionKinds[kind] {
+		return fmt.Errorf("%w: unknown reaction %q", ErrInvalidInput, kind)
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := reactionTarget(tx, target, targetID); err != nil {
+			return err // roll back
+		}
+		if err := tx.First(&User{}, userID).Error; err != nil {
+			return err // roll back
+		}
+
+		r := Reaction{UserID: userID, TargetType: target, TargetID: targetID, Kind: kind}
+		result := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "user_id"}, {Name: "target_type"}, {Name: "target_id"}},
+			DoNothing: true,
+		}).Create(&r)
+		if result.Error != nil {
+			return result.Error // roll back
+		}
+
+		if result.RowsAffected == 0 {
+			// already reacted, only the kind changes and the counter stays
+			return tx.Model(&Reaction{}).
+				Where("user_id = ? AND target_type = ? AND target_id = ?", userID, target, targetID).
+				Update("kind", kind).Error
+		}
+
+		if target == ReactionOnPost {
+			return tx.Model(&Post{}).
+				Where("id = ?", targetID).
+				UpdateColumn("reaction_count", gorm.Expr("reaction_count + 1")).Error
+		}
+		return nil
+	})
+}
+
+// Unreact removes the reaction of userID to a post or a comment, if any.
+func Unreact(db *gorm.DB, userID uint, target ReactionTarget, targetID uint) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("user_id = ? AND target_type = ? AND target_id = ?", userID, target, targetID).Delete(&Reaction{})
+		if result.Error != nil {
+			return result.Error // roll back
+		}
+		if result.RowsAffected == 0 || target != ReactionOnPost {
+			return nil
+		}
+
+		// the guard keeps a drifted counter from going negative, ReconcileReactionCounts repairs it
+		return tx.Model(&Post{}).
+			Where("id = ? AND reaction_count > 0", targetID).
+			UpdateColumn("reaction_count", gorm.Expr("reaction_count - 1")).Error
+	})
+}
+
+// ReactionSummary counts the reactions to a post or a comment by kind.
+func ReactionSummary(db *gorm.DB, target ReactionTarget, targetID uint) (map[ReactionKind]int64, error) {
+	if err := reactionTarget(db, target, targetID); err != nil {
+		return nil, err
+	}
+
+	var rows []struct {
+		Kind  ReactionKind
+		Count int64
+	}
+	if err := db.Model(&Reaction{}).
+		Select("kind, COUNT(*) AS count").
+		Where("target_type = ? AND target_id = ?", target, targetID).
+		Group("kind").
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	summary := make(map[ReactionKind]int64, len(rows))
+	for _, r := range rows {
+		summary[r.Kind] = r.Count
+	}
+	return summary, nil
+}
+
+// ReconcileReactionCounts recomputes Post.ReactionCount from the reactions table and returns the posts
+// whose counter had drifted. With fix, the counters are corrected; each correction is conditional on the
+// stored value, so a reaction landing meanwhile isn't overwritten.
+func ReconcileReactionCounts(db *gorm.DB, fix bool) ([]CounterDrift, error) {
+	var drifts []CounterDrift
+	if err := db.Model(&Post{}).
+		Select("posts.id AS post_id, posts.reaction_count AS stored, COUNT(reactions.id) AS actual").
+		Joins("LEFT JOIN reactions ON reactions.target_type = ? AND reactions.target_id = posts.id", ReactionOnPost).
+		Group("posts.id").
+		Having("posts.reaction_count <> COUNT(reactions.id)").
+		Order("posts.id").
+		Scan(&drifts).Error; err != nil {
+		return nil, err
+	}
+	if !fix {
+		return drifts, nil
+	}
+
+	for i, d := range drifts {
+		result := db.Model(&Post{}).
+			Where("id = ? AND reaction_count = ?", d.PostID, d.Stored).
+			UpdateColumn("reaction_count", d.Actual)
+		if result.Error != nil {
+			return drifts, result.Error
+		}
+		drifts[i].Fixed = result.RowsAffected > 0
+	}
+	return drifts, nil
+}
+
+func ReactionTest(db *gorm.DB) {
+	for userID := uint(1); userID <= 3; userID++ {
+		if err := React(db, userID, ReactionOnPost, 1, ReactionLike); err != nil {
+			panic(err)
+		}
+	}
+	// changes the kind, not the count
+	if err := React(db, 2, ReactionOnPost, 1, ReactionLove); err != nil {
+		panic(err)
+	}
+	if err := Unreact(db, 3, ReactionOnPost, 1); err != nil {
+		panic(err)
+	}
+
+	var p Post
+	if err := db.Select("id", "reaction_count").First(&p, 1).Error; err != nil {
+		panic(err)
+	}
+	summary, err := ReactionSummary(db, ReactionOnPost, 1)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println("reactions:", p.ReactionCount, summary)
+
+	// simulate a counter changed behind our back
+	db.Exec("UPDATE posts SET reaction_count = 42 WHERE id = ?", 1)
+	drifts, err := ReconcileReactionCounts(db, true)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("drift: %+v\n", drifts)
+}
@@ -0,0 +1,332 @@
+// Post revisions
+//
+// Every version of a post's Subject and Content is kept as an immutable PostRevision:
+//
+// | post_id | number | subject | content         | editor_id | restored_from |
+// | ------- | ------ | ------- | --------------- | --------- | ------------- |
+// | 1       | 1      | Hello   | first draft     | 1         | NULL          |
+// | 1       | 2      | Hello   | second draft    | 1         | NULL          |
+// | 1       | 3      | Hello   | first draft     | 2         | 1             |
+//
+// Revisions are written by the Post hooks, in the transaction of the INSERT or UPDATE itself:
+// - AfterCreate stores revision 1
+// - BeforeUpdate notices a change of Subject or Content, AfterUpdate stores the new text
+//
+// The editor is the user found in the statement context (WithUserID).
+// Batch updates without a primary key (Model(&Post{}).Where(...).Update(...)) have no single post to snapshot
+// and don't write revisions, go through UpdatePost to edit a post.
+
+package project
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var ErrRevisionImmutable = errors.New("post revisions are immutable")
+
+type PostRevision struct {
+	ID           uint      `gorm:"primaryKey"`
+	PostID       uint      `gorm:"not null;uniqueIndex:idx_post_revision,priority:1"` // FK
+	Number       int       `gorm:"not null;uniqueIndex:idx_post_revision,priority:2"` // 1 for the original text
+	Subject      string    `gorm:"size:255;not null"`
+	Content      string    `gorm:"not null"`
+	EditorID     *uint     // FK to the user who made the change, NULL when unknown
+	RestoredFrom *int      // Number of the restored revision, NULL for regular edits
+	CreatedAt    time.Time `gorm:"autoCreateTime"`
+}
+
+type DiffOp string
+
+const (
+	DiffEqual  DiffOp = " "
+	DiffInsert DiffOp = "+"
+	DiffDelete DiffOp = "-"
+)
+
+type DiffLine struct {
+	Op   DiffOp
+	Text string
+}
+
+func (l DiffLine) String() string {
+	return string(l.Op) + l.Text
+}
+
+// RevisionDiff is a line diff between two revisions of a post, from From to To.
+type RevisionDiff struct {
+	From    int
+	To      int
+	Subject []DiffLine
+	Content []DiffLine
+}
+
+type ctxKeyRestoredFrom struct{}
+
+const reviseKey = "project:revise"
+
+func (r *PostRevision) BeforeUpdate(tx *gorm.DB) error {
+	return ErrRevisionImmutable
+}
+
+func (p *Post) AfterCreate(tx *gorm.DB) error {
+	editorID := p.UserID
+	if uid, ok := userIDFrom(tx.Statement.Context); ok {
+		editorID = uid
+	}
+	return tx.Session(&gorm.Session{NewDB: true}).Create(&PostRevision{
+		PostID:   p.ID,
+		Number:   1,
+		Subject:  p.Subject,
+		Content:  p.Content,
+		EditorID: &editorID,
+	}).Error
+}
+
+func (p *Post) BeforeUpdate(tx *gorm.DB) error {
+	// the hooks share the statement of the UPDATE (InstanceSet would start a new one)
+	if p.ID != 0 && tx.Statement.Changed("Subject", "Content") {
+		tx.Statement.Settings.Store(reviseKey, true)
+	}
+	return nil
+}
+
+func (p *Post) AfterUpdate(tx *gorm.DB) error {
+	if _, ok := tx.Statement.Settings.LoadAndDelete(reviseKey); !ok {
+		return nil
+	}
+
+	// the model may only hold the updated columns, read the whole text back
+	db := tx.Session(&gorm.Session{NewDB: true})
+	var current Post
+	if err := db.Select("id", "subject", "content").First(&current, p.ID).Error; err != nil {
+		return err
+	}
+	return writeRevision(db, &current)
+}
+
+// writeRevision stores the text of p as its next revision.
+func writeRevision(tx *gorm.DB, p *Post) error {
+	var last int
+	if err := tx.Model(&PostRevision{}).
+		Where("post_id = ?", p.ID).
+		Select("COALESCE(MAX(number), 0)").
+		Scan(&last).Error; err != nil {
+		return err
+	}
+
+	rev := PostRevision{
+		PostID:  p.ID,
+		Number:  last + 1,
+		Subject: p.Subject,
+		Content: p.Content,
+	}
+	if uid, ok := userIDFrom(tx.Statement.Context); ok {
+		rev.EditorID = &uid
+	}
+	if n, ok := tx.Statement.Context.Value(ctxKeyRestoredFrom{}).(int); ok {
+		rev.RestoredFrom = &n
+	}
+	// (post_id, number) is unique, a concurrent edit fails instead of sharing the number
+	return tx.Create(&rev).Error
+}
+
+// backfillRevisions gives a first revision to the posts created before revisions existed.
+func backfillRevisions(db *gorm.DB) error {
+	return db.Exec(`
+		INSERT INTO post_revisions (post_id, number, subject, content, editor_id, created_at)
+		SELECT id, 1, subject, content, user_id, created_at FROM posts
+		WHERE id NOT IN (SELECT post_id FROM post_revisions)
+	`).Error
+}
+
+// UpdatePost replaces the subject and content of a post, the previous text stays in its revisions.
+func UpdatePost(db *gorm.DB, postID uint, subject, content string) (*Post, error) {
+	if err := validatePost(subject, content); err != nil {
+		return nil, err
+	}
+
+	var p Post
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&p, postID).Error; err != nil {
+			return err // roll back
+		}
+		return tx.Model(&p).Updates(map[string]any{"subject": subject, "content": content}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// ListRevisions returns the revisions of a visible post, latest first.
+func ListRevisions(db *gorm.DB, postID uint) ([]PostRevision, error) {
+	if err := db.Scopes(visiblePosts).Select("id").First(&Post{}, postID).Error; err != nil {
+		return nil, err
+	}
+
+	var revs []PostRevision
+	if err := db.Where("post_id = ?", postID).Order("number DESC").Find(&revs).Error; err != nil {
+		return nil, err
+	}
+	return revs, nil
+}
+
+func findRevision(tx *gorm.DB, postID uint, number int) (*PostRevision, error) {
+	var rev PostRevision
+	if err := tx.Where("post_id = ? AND number = ?", postID, number).First(&rev).Error; err != nil {
+		return nil, err
+	}
+	return &rev, nil
+}
+
+// DiffRevisions compares the revisions from and to of a visible post line by line.
+func DiffRevisions(db *gorm.DB, postID uint, from, to int) (*RevisionDiff, error) {
+	var diff *RevisionDiff
+	// one transaction so both revisions are read from the same snapshot
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Scopes(visiblePosts).Select("id").First(&Post{}, postID).Error; err != nil {
+			return err // roll back
+		}
+		a, err := findRevision(tx, postID, from)
+		if err != nil {
+			return err // roll back
+		}
+		b, err := findRevision(tx, postID, to)
+		if err != nil {
+			return err // roll back
+		}
+
+		diff = &RevisionDiff{
+			From:    from,
+			To:      to,
+			Subject: diffLines(splitLines(a.Subject), splitLines(b.Subject)),
+			Content: diffLines(splitLines(a.Content), splitLines(b.Content)),
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return diff, nil
+}
+
+// RestoreRevision puts the text of revision number back on the post, recorded as a new revision.
+func RestoreRevision(db *gorm.DB, postID uint, number int) (*PostRevision, error) {
+	var restored PostRevision
+	err := db.Transaction(func(tx *gorm.DB) error {
+		rev, err := findRevision(tx, postID, number)
+		if err != nil {
+			return err // roll back
+		}
+
+		var p Post
+		if err := tx.First(&p, postID).Error; err != nil {
+			return err // roll back
+		}
+		if p.Subject == rev.Subject && p.Content == rev.Content {
+			return fmt.Errorf("%w: post already has the text of revision %d", ErrInvalidInput, number) // roll back
+		}
+
+		// the AfterUpdate hook writes the new revision and marks it as restored
+		ctx := context.WithValue(tx.Statement.Context, ctxKeyRestoredFrom{}, number)
+		if err := tx.WithContext(ctx).Model(&p).Updates(map[string]any{
+			"subject": rev.Subject,
+			"content": rev.Content,
+		}).Error; err != nil {
+			return err // roll back
+		}
+
+		return tx.Where("post_id = ?", postID).Order("number DESC").First(&restored).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &restored, nil
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.ReplaceAll(s, "\r\n", "\n"), "\n")
+}
+
+// diffLines turns a into b with the fewest inserted and deleted lines,
+// using a longest common subsequence table (O(len(a)*len(b)), fine for blog posts).
+func diffLines(a, b []string) []DiffLine {
+	// lcs[i][j] is the LCS length of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var diff []DiffLine
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			diff = append(diff, DiffLine{DiffEqual, a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			diff = append(diff, DiffLine{DiffDelete, a[i]})
+			i++
+		default:
+			diff = append(diff, DiffLine{DiffInsert, b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		diff = append(diff, DiffLine{DiffDelete, a[i]})
+	}
+	for ; j < len(b); j++ {
+		diff = append(diff, DiffLine{DiffInsert, b[j]})
+	}
+	return diff
+}
+
+func PostRevisionTest(db *gorm.DB) {
+	ctx := WithUserID(db.Statement.Context, 1)
+	p, err := CreatePost(db.WithContext(ctx), 1, "Release notes", "Faster queries\nNew logo")
+	if err != nil {
+		panic(err)
+	}
+	if _, err := UpdatePost(db.WithContext(ctx), p.ID, "Release notes", "Faster queries\nSmaller binaries\nNew logo"); err != nil {
+		panic(err)
+	}
+
+	diff, err := DiffRevisions(db.WithContext(ctx), p.ID, 1, 2)
+	if err != nil {
+		panic(err)
+	}
+	for _, l := range diff.Content {
+		fmt.Println(l)
+	}
+
+	rev, err := RestoreRevision(db.WithContext(ctx), p.ID, 1)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("revision %d restored from %d\n", rev.Number, *rev.RestoredFrom)
+
+	revs, err := ListRevisions(db.WithContext(ctx), p.ID)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println("revisions:", len(revs))
+}
@@ -0,0 +1,219 @@
+// Full-text search over posts with SQLite FTS5.
+//
+// posts_fts is an external content FTS5 table: it only stores the inverted index and reads
+// subject/content back from the posts table (content='posts', content_rowid='id').
+// Triggers created at migration time keep the index in sync on INSERT, UPDATE and DELETE,
+// so every write path (GORM, raw SQL, other tools) is covered, not only the ones going through hooks.
+//
+// FTS5 is an optional SQLite module, mattn/go-sqlite3 only compiles it with a build tag:
+//
+// go build -tags sqlite_fts5 ./...
+//
+// Without it Migrate skips the search table and SearchPosts returns ErrSearchUnavailable. The triggers stay in
+// the database file though, and every write to posts would then fail with "no such module: fts5", so Migrate
+// drops them when FTS5 is missing. The next migration built with the tag recreates them and rebuilds the index
+// to catch up with the posts written meanwhile.
+
+package project
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+var ErrSearchUnavailable = errors.New("full-text search is unavailable, build with -tags sqlite_fts5")
+
+// markers wrapped around matches by highlight()/snippet(), replaced by <mark> once the text is escaped
+const (
+	matchStart = "\x02"
+	matchEnd   = "\x03"
+)
+
+type SearchQuery struct {
+	Query  string // keywords, all of them must match, a trailing * matches a prefix
+	TagIDs []uint // only posts carrying at least one of these tags
+	UserID uint   // only posts of this author, 0 means any author
+	Page   int    // 1-based
+	Size   int
+}
+
+type SearchHit struct {
+	Post             Post
+	Rank             float64 // bm25 score, lower is better
+	SubjectHighlight string  // HTML escaped subject with <mark> around matches
+	ContentSnippet   string  // HTML escaped excerpt of the content with <mark> around matches
+}
+
+func searchAvailable(db *gorm.DB) bool {
+	if db.Dialector.Name() != "sqlite" {
+		return false
+	}
+	var used bool
+	if err := db.Raw("SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&used).Error; err != nil {
+		return false
+	}
+	return used
+}
+
+var searchTriggers = []string{"posts_fts_ai", "posts_fts_ad", "posts_fts_au"}
+
+func migrateSearch(db *gorm.DB) error {
+	if !searchAvailable(db) {
+		if db.Dialector.Name() != "sqlite" {
+			return nil
+		}
+		// left by a build with FTS5, they would break every write to posts
+		for _, name := range searchTriggers {
+			if err := db.Exec("DROP TRIGGER IF EXISTS " + name).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// index rows that existed before the search table was introduced, or were written while the triggers were dropped
+	var triggers int64
+	if err := db.Raw("SELECT COUNT(*) FROM sqlite_master WHERE type = 'trigger' AND name IN ?", searchTriggers).
+		Scan(&triggers).Error; err != nil {
+		return err
+	}
+	rebuild := !db.Migrator().HasTable("posts_fts") || triggers < int64(len(searchTriggers))
+
+	stmts := []string{
+		`CREATE VIRTUAL TABLE IF NOT EXISTS posts_fts USING fts5(
+			subject, content,
+			content='posts', content_rowid='id',
+			tokenize='porter unicode61'
+		)`,
+		`CREATE TRIGGER IF NOT EXISTS posts_fts_ai AFTER INSERT ON posts BEGIN
+			INSERT INTO posts_fts(rowid, subject, content) VALUES (new.id, new.subject, new.content);
+		END`,
+		`CREATE TRIGGER IF NOT EXISTS posts_fts_ad AFTER DELETE ON posts BEGIN
+			INSERT INTO posts_fts(posts_fts, rowid, subject, content) VALUES ('delete', old.id, old.subject, old.content);
+		END`,
+		`CREATE TRIGGER IF NOT EXISTS posts_fts_au AFTER UPDATE OF subject, content ON posts BEGIN
+			INSERT INTO posts_fts(posts_fts, rowid, subject, content) VALUES ('delete', old.id, old.subject, old.content);
+			INSERT INTO posts_fts(rowid, subject, content) VALUES (new.id, new.subject, new.content);
+		END`,
+	}
+	if rebuild {
+		stmts = append(stmts, `INSERT INTO posts_fts(posts_fts) VALUES ('rebuild')`)
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, stmt := range stmts {
+			if err := tx.Exec(stmt).Error; err != nil {
+				return err // roll back
+			}
+		}
+		return nil
+	})
+}
+
+// matchExpr turns user input into an FTS5 query. Every keyword is quoted,
+// so characters such as - : ( ) are searched literally instead of being parsed as FTS5 syntax.
+func matchExpr(q string) string {
+	var terms []string
+	for _, f := range strings.Fields(q) {
+		prefix := strings.HasSuffix(f, "*")
+		f = strings.TrimRight(f, "*")
+		if f == "" {
+			continue
+		}
+
+		term := `"` + strings.ReplaceAll(f, `"`, `""`) + `"`
+		if prefix {
+			term += "*"
+		}
+		terms = append(terms, term)
+	}
+	return strings.Join(terms, " ")
+}
+
+func markMatches(s string) string {
+	s = html.EscapeString(s)
+	s = strings.ReplaceAll(s, matchStart, "<mark>")
+	return strings.ReplaceAll(s, matchEnd, "</mark>")
+}
+
+// SearchPosts finds visible posts whose subject or content match q.Query, best matches first.
+// A match in the subject weighs ten times more than one in the content.
+func SearchPosts(db *gorm.DB, q SearchQuery) ([]SearchHit, error) {
+	match := matchExpr(q.Query)
+	if match == "" {
+		return nil, fmt.Errorf("%w: query is required", ErrInvalidInput)
+	}
+	if q.Page <= 0 {
+		q.Page = 1
+	}
+	if q.Size <= 0 || q.Size > maxLimit {
+		return nil, fmt.Errorf("%w: size must be between 1 and %d", ErrInvalidInput, maxLimit)
+	}
+	if !searchAvailable(db) {
+		return nil, ErrSearchUnavailable
+	}
+
+	type row struct {
+		ID               uint
+		Rank             float64
+		SubjectHighlight string
+		ContentSnippet   string
+	}
+
+	tx := db.Table("posts_fts").
+		Select(
+			"posts.id, bm25(posts_fts, 10.0, 1.0) AS rank, highlight(posts_fts, 0, ?, ?) AS subject_highlight, snippet(posts_fts, 1, ?, ?, '…', 16) AS content_snippet",
+			matchStart, matchEnd, matchStart, matchEnd,
+		).
+		Joins("JOIN posts ON posts.id = posts_fts.rowid").
+		Where("posts_fts MATCH ? AND posts.deleted_at IS NULL", match).
+		Scopes(visiblePosts)
+
+	if len(q.TagIDs) > 0 {
+		tx = tx.Where("posts.id IN (SELECT post_id FROM post_tags WHERE tag_id IN ?)", q.TagIDs)
+	}
+	if q.UserID != 0 {
+		tx = tx.Where("posts.user_id = ?", q.UserID)
+	}
+
+	var rows []row
+	if err := tx.
+		Order("rank, posts.id").
+		Limit(q.Size).
+		Offset((q.Page - 1) * q.Size).
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return []SearchHit{}, nil
+	}
+
+	ids := make([]uint, len(rows))
+	for i, r := range rows {
+		ids[i] = r.ID
+	}
+	var posts []Post
+	if err := db.Preload("Tags").Find(&posts, ids).Error; err != nil { // already filtered by visibility
+		return nil, err
+	}
+	byID := make(map[uint]Post, len(posts))
+	for _, p := range posts {
+		byID[p.ID] = p
+	}
+
+	// keep the rank order of the FTS query
+	hits := make([]SearchHit, 0, len(rows))
+	for _, r := range rows {
+		hits = append(hits, SearchHit{
+			Post:             byID[r.ID],
+			Rank:             r.Rank,
+			SubjectHighlight: markMatches(r.SubjectHighlight),
+			ContentSnippet:   markMatches(r.ContentSnippet),
+		})
+	}
+	return hits, nil
+}
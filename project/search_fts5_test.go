@@ -0,0 +1,130 @@
+//go:build sqlite_fts5
+
+package project
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestSearchPosts(t *testing.T) {
+	db := openTestDB(t, DefaultConfig())
+	if !searchAvailable(db) {
+		t.Fatal("built with sqlite_fts5 but FTS5 is unavailable")
+	}
+	seeded, err := Seed(db, SeedOptions{Seed: 1, Users: 2, Posts: 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice, bob := seeded.UserIDs[0], seeded.UserIDs[1]
+	goTag, dbTag := seeded.TagIDs[0], seeded.TagIDs[1]
+
+	publish := func(userID uint, subject, content string, tagIDs ...uint) uint {
+		t.Helper()
+		p, err := PublishPostWithTags(db, userID, subject, content, tagIDs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p.ID
+	}
+	inSubject := publish(alice, "Indexing tables", "Pick the columns carefully.", goTag)
+	inContent := publish(bob, "Weekly notes", "A word on indexing, then <b>lunch</b>.", dbTag)
+	literal := publish(alice, "C++ and co-routines", "Nothing to see here.")
+	deleted := publish(bob, "Indexing, deleted", "Soon gone.")
+	if err := DeletePost(db, deleted); err != nil {
+		t.Fatal(err)
+	}
+	draft, err := CreatePost(db, alice, "Indexing draft", "Not published yet.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	renamed := publish(bob, "Old subject", "Plain content.")
+	if _, err := UpdatePost(db, renamed, "Migrations", "Plain content."); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		q       SearchQuery
+		wantIDs []uint
+	}{
+		{"subject weighs more than content", SearchQuery{Query: "indexing", Size: 10}, []uint{inSubject, inContent}},
+		{"stemmed", SearchQuery{Query: "indexes", Size: 10}, []uint{inSubject, inContent}},
+		{"prefix", SearchQuery{Query: "index*", Size: 10}, []uint{inSubject, inContent}},
+		{"every keyword must match", SearchQuery{Query: "indexing lunch", Size: 10}, []uint{inContent}},
+		{"fts5 syntax is searched literally", SearchQuery{Query: "co-routines", Size: 10}, []uint{literal}},
+		{"unbalanced quotes", SearchQuery{Query: `"c++`, Size: 10}, []uint{literal}},
+		{"by tag", SearchQuery{Query: "indexing", TagIDs: []uint{dbTag}, Size: 10}, []uint{inContent}},
+		{"by author", SearchQuery{Query: "indexing", UserID: alice, Size: 10}, []uint{inSubject}},
+		{"second page", SearchQuery{Query: "indexing", Page: 2, Size: 1}, []uint{inContent}},
+		{"updates are reindexed", SearchQuery{Query: "migrations", Size: 10}, []uint{renamed}},
+		{"the old subject is gone", SearchQuery{Query: "old", Size: 10}, []uint{}},
+		{"no match", SearchQuery{Query: "nothing*matches", Size: 10}, []uint{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hits, err := SearchPosts(db, tt.q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ids := []uint{}
+			for _, h := range hits {
+				ids = append(ids, h.Post.ID)
+			}
+			if fmt.Sprint(ids) != fmt.Sprint(tt.wantIDs) {
+				t.Fatalf("got %v, want %v", ids, tt.wantIDs)
+			}
+		})
+	}
+
+	// the author sees their draft, nobody else does
+	hits, err := SearchPosts(db.WithContext(WithUserID(db.Statement.Context, alice)), SearchQuery{Query: "draft", Size: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hits) != 1 || hits[0].Post.ID != draft.ID {
+		t.Fatalf("author search: got %+v, want the draft", hits)
+	}
+
+	// matches are marked, the rest of the text is escaped
+	hits, err = SearchPosts(db, SearchQuery{Query: "lunch", Size: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hits) != 1 {
+		t.Fatalf("got %d hits, want 1", len(hits))
+	}
+	if want := "A word on indexing, then &lt;b&gt;<mark>lunch</mark>&lt;/b&gt;."; hits[0].ContentSnippet != want {
+		t.Errorf("snippet %q, want %q", hits[0].ContentSnippet, want)
+	}
+	if hits[0].SubjectHighlight != "Weekly notes" {
+		t.Errorf("subject highlight %q, want it unmarked", hits[0].SubjectHighlight)
+	}
+
+	if _, err := SearchPosts(db, SearchQuery{Query: " * ", Size: 10}); !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("empty query: got %v, want ErrInvalidInput", err)
+	}
+}
+
+func TestAPISearchPosts(t *testing.T) {
+	srv, seeded := newTestServer(t, DefaultConfig(), 1)
+	author := seeded.UserIDs[0]
+
+	var published Post
+	if code := call(t, srv, "POST", "/posts/publish", author, publishPostRequest{Subject: "Keyset pagination", Content: "Seek, don't skip."}, &published); code != http.StatusCreated {
+		t.Fatalf("publish: got %d", code)
+	}
+
+	var hits []SearchHit
+	if code := call(t, srv, "GET", "/posts/search?q=keyset", 0, nil, &hits); code != http.StatusOK {
+		t.Fatalf("search: got %d", code)
+	}
+	if len(hits) != 1 || hits[0].Post.ID != published.ID || hits[0].SubjectHighlight != "<mark>Keyset</mark> pagination" {
+		t.Fatalf("search: got %+v", hits)
+	}
+	if code := call(t, srv, "GET", "/posts/search?q=", 0, nil, nil); code != http.StatusBadRequest {
+		t.Fatalf("empty query: got %d, want 400", code)
+	}
+}
@@ -0,0 +1,25 @@
+//go:build !sqlite_fts5
+
+package project
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+)
+
+// The search itself is tested by search_fts5_test.go, run with go test -tags sqlite_fts5.
+func TestSearchUnavailable(t *testing.T) {
+	srv, seeded := newTestServer(t, DefaultConfig(), 1)
+	if code := call(t, srv, "POST", "/posts/publish", seeded.UserIDs[0], publishPostRequest{Subject: "Hello", Content: "World"}, nil); code != http.StatusCreated {
+		t.Fatalf("publish without FTS5: got %d", code)
+	}
+	if code := call(t, srv, "GET", "/posts/search?q=hello", 0, nil, nil); code != http.StatusNotImplemented {
+		t.Fatalf("search: got %d, want 501", code)
+	}
+
+	db := openTestDB(t, DefaultConfig())
+	if _, err := SearchPosts(db, SearchQuery{Query: "hello", Size: 10}); !errors.Is(err, ErrSearchUnavailable) {
+		t.Fatalf("got %v, want ErrSearchUnavailable", err)
+	}
+}
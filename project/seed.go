@@ -0,0 +1,445 @@
+// Deterministic blog seeding
+//
+// Every random choice is drawn from a rand.Rand created from SeedOptions.Seed, in a fixed order
+// that doesn't depend on what is already in the database, and timestamps are derived from BaseTime.
+// So the same options always describe the same data set, which integration tests and benchmarks can rely on.
+//
+// Rows have natural keys so a seed can be applied again:
+// - users: Email (unique index)
+// - tags: Name (unique index)
+// - posts: (UserID, Subject), subjects are numbered "Post Subject N"
+// - comments: Content, numbered "Comment N content"
+// - follows: (FollowerID, FolloweeID), existing follows are kept
+//
+// With Upsert, rows found by their natural key are updated to the seeded values instead of
+// failing on the unique indexes, and a post's tags are replaced by the seeded set.
+
+package project
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type SeedOptions struct {
+	Seed        int64
+	Users       int
+	Posts       int // spread round-robin over the users
+	Comments    int
+	TagsPerPost int // every post gets 1..TagsPerPost distinct tags, at most len(Tags)
+	Follows     int // every user follows 0..Follows other users, at most Users-1
+	Upsert      bool
+	BaseTime    time.Time // CreatedAt of the first post, zero means 2024-01-01 UTC
+	BatchSize   int       // rows per INSERT, zero means 500
+}
+
+// DefaultSeedOptions is the small data set used by BlogTest.
+var DefaultSeedOptions = SeedOptions{
+	Seed:        1,
+	Users:       5,
+	Posts:       20,
+	Comments:    10,
+	TagsPerPost: 3,
+	Follows:     2,
+	Upsert:      true,
+}
+
+// SeedResult holds the IDs of the seeded rows, in seeding order.
+type SeedResult struct {
+	UserIDs    []uint
+	TagIDs     []uint
+	PostIDs    []uint
+	CommentIDs []uint
+}
+
+// postTag is a row of the post_tags join table managed by the Post.Tags many2many association.
+type postTag struct {
+	PostID uint `gorm:"primaryKey"`
+	TagID  uint `gorm:"primaryKey"`
+}
+
+func (postTag) TableName() string {
+	return "post_tags"
+}
+
+var seedWords = strings.Fields(`
+	go gorm sqlite database index query transaction migration schema cursor
+	backend cloud cache latency throughput replica shard consistency lock deadlock
+	hook scope preload join association model table column constraint benchmark
+`)
+
+func SeedBlogData(db *gorm.DB) error {
+	_, err := Seed(db, DefaultSeedOptions)
+	return err
+}
+
+// Seed inserts (or with Upsert, reconciles) the data set described by opts in one transaction.
+func Seed(db *gorm.DB, opts SeedOptions) (*SeedResult, error) {
+	if opts.Users <= 0 {
+		return nil, fmt.Errorf("%w: at least one user is required", ErrInvalidInput)
+	}
+	if opts.Posts < 0 || opts.Comments < 0 || opts.TagsPerPost < 0 || opts.Follows < 0 {
+		return nil, fmt.Errorf("%w: volumes must not be negative", ErrInvalidInput)
+	}
+	if opts.Comments > 0 && opts.Posts == 0 {
+		return nil, fmt.Errorf("%w: comments need at least one post", ErrInvalidInput)
+	}
+	if opts.TagsPerPost > len(Tags) {
+		opts.TagsPerPost = len(Tags)
+	}
+	if opts.Follows > opts.Users-1 {
+		opts.Follows = opts.Users - 1
+	}
+	if opts.BaseTime.IsZero() {
+		opts.BaseTime = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 500
+	}
+
+	r := rand.New(rand.NewSource(opts.Seed))
+	res := &SeedResult{}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := seedUsers(tx, opts, res); err != nil {
+			return err // roll back
+		}
+		if err := seedTags(tx, opts, res); err != nil {
+			return err // roll back
+		}
+		if err := seedPosts(tx, opts, r, res); err != nil {
+			return err // roll back
+		}
+		if err := seedComments(tx, opts, r, res); err != nil {
+			return err // roll back
+		}
+		if err := seedFollows(tx, opts, r, res); err != nil {
+			return err // roll back
+		}
+
+		// seeded posts don't go through the publish path, fan them out in one go
+		if configFrom(tx.Statement.Context).FeedFanOutOnWrite {
+			return RebuildFeed(tx)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func seedUsers(tx *gorm.DB, opts SeedOptions, res *SeedResult) error {
+	users := make([]User, opts.Users)
+	emails := make([]string, opts.Users)
+	for i := range users {
+		users[i] = User{
+			Name:  fmt.Sprintf("user_%d", i+1),
+			Email: fmt.Sprintf("user_%d@test.com", i+1),
+			// the first user moderates the comments
+			Moderator: i == 0,
+		}
+		emails[i] = users[i].Email
+	}
+
+	if !opts.Upsert {
+		if err := tx.CreateInBatches(&users, opts.BatchSize).Error; err != nil {
+			return err
+		}
+		for _, u := range users {
+			res.UserIDs = append(res.UserIDs, u.ID)
+		}
+		return nil
+	}
+
+	if err := tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "email"}},
+		DoUpdates: clause.AssignmentColumns([]string{"name", "moderator"}),
+	}).CreateInBatches(&users, opts.BatchSize).Error; err != nil {
+		return err
+	}
+
+	// upserted rows don't always report their ID, read them back by natural key
+	ids := make(map[string]uint, len(emails))
+	for _, batch := range chunk(emails, opts.BatchSize) {
+		var found []User
+		if err := tx.Select("id", "email").Where("email IN ?", batch).Find(&found).Error; err != nil {
+			return err
+		}
+		for _, u := range found {
+			ids[u.Email] = u.ID
+		}
+	}
+	for _, e := range emails {
+		res.UserIDs = append(res.UserIDs, ids[e])
+	}
+	return nil
+}
+
+func seedTags(tx *gorm.DB, opts SeedOptions, res *SeedResult) error {
+	// copy, so the package level Tags keep their zero IDs
+	tags := make([]Tag, len(Tags))
+	names := make([]string, len(Tags))
+	for i, t := range Tags {
+		tags[i] = Tag{Name: t.Name}
+		names[i] = t.Name
+	}
+
+	if !opts.Upsert {
+		if err := tx.Create(&tags).Error; err != nil {
+			return err
+		}
+		for _, t := range tags {
+			res.TagIDs = append(res.TagIDs, t.ID)
+		}
+		return nil
+	}
+
+	if err := tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "name"}},
+		DoNothing: true,
+	}).Create(&tags).Error; err != nil {
+		return err
+	}
+
+	var found []Tag
+	if err := tx.Select("id", "name").Where("name IN ?", names).Find(&found).Error; err != nil {
+		return err
+	}
+	ids := make(map[string]uint, len(found))
+	for _, t := range found {
+		ids[t.Name] = t.ID
+	}
+	for _, n := range names {
+		res.TagIDs = append(res.TagIDs, ids[n])
+	}
+	return nil
+}
+
+func seedPosts(tx *gorm.DB, opts SeedOptions, r *rand.Rand, res *SeedResult) error {
+	posts := make([]Post, opts.Posts)
+	links := make([][]uint, opts.Posts)
+	for i := range posts {
+		createdAt := opts.BaseTime.Add(time.Duration(i) * time.Minute)
+		posts[i] = Post{
+			Subject:     fmt.Sprintf("Post Subject %d", i+1),
+			Content:     seedSentence(r, 8+r.Intn(24)),
+			UserID:      res.UserIDs[i%len(res.UserIDs)],
+			CreatedAt:   createdAt,
+			UpdatedAt:   createdAt,
+			Status:      PostPublished,
+			PublishedAt: &createdAt,
+		}
+
+		// distinct tags: the first n entries of a permutation
+		if opts.TagsPerPost > 0 {
+			n := r.Intn(opts.TagsPerPost) + 1
+			for _, j := range r.Perm(len(res.TagIDs))[:n] {
+				links[i] = append(links[i], res.TagIDs[j])
+			}
+		}
+	}
+
+	var missing []*Post
+	if opts.Upsert {
+		existing, err := existingPosts(tx, posts, opts.BatchSize)
+		if err != nil {
+			return err
+		}
+		for i := range posts {
+			p := &posts[i]
+			old, ok := existing[postKey{p.UserID, p.Subject}]
+			if !ok {
+				missing = append(missing, p)
+				continue
+			}
+
+			p.ID = old.ID
+			if old.Content != p.Content || !old.CreatedAt.Equal(p.CreatedAt) || old.Status != p.Status {
+				if err := tx.Unscoped().Model(&old).Updates(map[string]any{
+					"content":      p.Content,
+					"created_at":   p.CreatedAt,
+					"updated_at":   p.UpdatedAt,
+					"status":       p.Status,
+					"published_at": p.PublishedAt,
+				}).Error; err != nil {
+					return err
+				}
+			}
+		}
+	} else {
+		for i := range posts {
+			missing = append(missing, &posts[i])
+		}
+	}
+
+	for _, batch := range chunk(missing, opts.BatchSize) {
+		rows := make([]Post, len(batch))
+		for i, p := range batch {
+			rows[i] = *p
+		}
+		if err := tx.Create(&rows).Error; err != nil {
+			return err
+		}
+		for i, p := range batch {
+			p.ID = rows[i].ID
+		}
+	}
+
+	var pts []postTag
+	for i, p := range posts {
+		res.PostIDs = append(res.PostIDs, p.ID)
+		for _, tagID := range links[i] {
+			pts = append(pts, postTag{PostID: p.ID, TagID: tagID})
+		}
+	}
+
+	// replace the tag sets of the seeded posts
+	if opts.Upsert {
+		for _, ids := range chunk(res.PostIDs, opts.BatchSize) {
+			if err := tx.Where("post_id IN ?", ids).Delete(&postTag{}).Error; err != nil {
+				return err
+			}
+		}
+	}
+	if len(pts) == 0 {
+		return nil
+	}
+	return tx.Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(&pts, opts.BatchSize).Error
+}
+
+type postKey struct {
+	UserID  uint
+	Subject string
+}
+
+func existingPosts(tx *gorm.DB, posts []Post, batchSize int) (map[postKey]Post, error) {
+	subjects := make([]string, len(posts))
+	for i, p := range posts {
+		subjects[i] = p.Subject
+	}
+
+	existing := make(map[postKey]Post)
+	for _, batch := range chunk(subjects, batchSize) {
+		var found []Post
+		// Unscoped: a post deleted since the last run is still the same post
+		if err := tx.Unscoped().Where("subject IN ?", batch).Find(&found).Error; err != nil {
+			return nil, err
+		}
+		for _, p := range found {
+			existing[postKey{p.UserID, p.Subject}] = p
+		}
+	}
+	return existing, nil
+}
+
+func seedComments(tx *gorm.DB, opts SeedOptions, r *rand.Rand, res *SeedResult) error {
+	comments := make([]Comment, opts.Comments)
+	contents := make([]string, opts.Comments)
+	for i := range comments {
+		comments[i] = Comment{
+			PostID:  res.PostIDs[r.Intn(len(res.PostIDs))],
+			UserID:  res.UserIDs[r.Intn(len(res.UserIDs))],
+			Content: fmt.Sprintf("Comment %d content", i+1),
+		}
+		contents[i] = comments[i].Content
+	}
+
+	existing := make(map[string]Comment)
+	if opts.Upsert {
+		for _, batch := range chunk(contents, opts.BatchSize) {
+			var found []Comment
+			// Unscoped: a comment deleted since the last run is still the same comment
+			if err := tx.Unscoped().Where("content IN ?", batch).Find(&found).Error; err != nil {
+				return err
+			}
+			for _, c := range found {
+				existing[c.Content] = c
+			}
+		}
+	}
+
+	var missing []Comment
+	for i, c := range comments {
+		old, ok := existing[c.Content]
+		if !ok {
+			missing = append(missing, c)
+			continue
+		}
+
+		comments[i].ID = old.ID
+		if old.PostID != c.PostID || old.UserID != c.UserID {
+			if err := tx.Unscoped().Model(&old).Updates(map[string]any{
+				"post_id": c.PostID,
+				"user_id": c.UserID,
+			}).Error; err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(missing) > 0 {
+		if err := tx.CreateInBatches(&missing, opts.BatchSize).Error; err != nil {
+			return err
+		}
+	}
+	created := make(map[string]uint, len(missing))
+	for _, c := range missing {
+		created[c.Content] = c.ID
+	}
+	for _, c := range comments {
+		if c.ID == 0 {
+			c.ID = created[c.Content]
+		}
+		res.CommentIDs = append(res.CommentIDs, c.ID)
+	}
+	return nil
+}
+
+func seedFollows(tx *gorm.DB, opts SeedOptions, r *rand.Rand, res *SeedResult) error {
+	if opts.Follows == 0 {
+		return nil
+	}
+
+	var follows []Follow
+	for i, follower := range res.UserIDs {
+		n := r.Intn(opts.Follows + 1)
+		// distinct followees other than the follower: skip the follower's own index
+		for _, j := range r.Perm(len(res.UserIDs) - 1)[:n] {
+			if j >= i {
+				j++
+			}
+			follows = append(follows, Follow{FollowerID: follower, FolloweeID: res.UserIDs[j]})
+		}
+	}
+	if len(follows) == 0 {
+		return nil
+	}
+	return tx.Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(&follows, opts.BatchSize).Error
+}
+
+func seedSentence(r *rand.Rand, n int) string {
+	words := make([]string, n)
+	for i := range words {
+		words[i] = seedWords[r.Intn(len(seedWords))]
+	}
+	return strings.ToUpper(words[0][:1]) + strings.Join(words, " ")[1:] + "."
+}
+
+// chunk splits s into slices of at most size elements.
+func chunk[T any](s []T, size int) [][]T {
+	var out [][]T
+	for len(s) > size {
+		out = append(out, s[:size])
+		s = s[size:]
+	}
+	if len(s) > 0 {
+		out = append(out, s)
+	}
+	return out
+}
@@ -0,0 +1,290 @@
+// RSS and Atom feeds
+//
+// Three feeds are published, each as RSS 2.0 and Atom 1.0:
+//
+// | Feed  | Posts                                    | Built on           |
+// | ----- | ---------------------------------------- | ------------------ |
+// | site  | latest published posts of every user     | ListPosts          |
+// | user  | latest published posts of one user       | GetUserLatestPosts |
+// | tag   | latest published posts carrying one tag  | GetTagPosts        |
+//
+// Feeds are public, they never list drafts, even those of the caller.
+//
+// Every entry is identified by a tag URI (RFC 4151) that doesn't depend on the host serving the feed:
+//
+// tag:blog.example.com,2024:post/42
+//
+// so readers don't show a post twice when the blog moves, FeedTagAuthority must not change once feeds are out.
+// Entries carry the HTML rendered from the Markdown (see markdown.go), <updated> is Post.UpdatedAt.
+
+package project
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// FeedTagAuthority is the authority of the tag URIs identifying feeds and entries: a domain (or email) owned by
+// the blog and a date at which it was, see RFC 4151.
+var FeedTagAuthority = "blog.example.com,2024"
+
+// Syndication is the content of a feed, rendered by RSS and Atom.
+type Syndication struct {
+	ID      string // tag URI of the feed
+	Title   string
+	Path    string    // of the posts listed by the feed, relative to the base URL
+	Updated time.Time // latest UpdatedAt of the posts, the Unix epoch for an empty feed
+	Posts   []Post
+	Authors map[uint]string // user id -> name
+}
+
+func feedTagURI(specific string) string {
+	return "tag:" + FeedTagAuthority + ":" + specific
+}
+
+// publicPosts drops the caller from the context, feeds only list published posts.
+func publicPosts(db *gorm.DB) *gorm.DB {
+	return db.WithContext(WithUserID(db.Statement.Context, 0))
+}
+
+// newSyndication fills in the authors and the updated time of posts.
+func newSyndication(db *gorm.DB, id, title, path string, posts []Post) (*Syndication, error) {
+	s := &Syndication{
+		ID:      feedTagURI(id),
+		Title:   title,
+		Path:    path,
+		Updated: time.Unix(0, 0).UTC(),
+		Posts:   posts,
+		Authors: make(map[uint]string),
+	}
+
+	var userIDs []uint
+	for _, p := range posts {
+		if p.UpdatedAt.After(s.Updated) {
+			s.Updated = p.UpdatedAt
+		}
+		userIDs = append(userIDs, p.UserID)
+	}
+	if len(userIDs) == 0 {
+		return s, nil
+	}
+
+	var users []User
+	if err := db.Select("id", "name").Find(&users, userIDs).Error; err != nil {
+		return nil, err
+	}
+	for _, u := range users {
+		s.Authors[u.ID] = u.Name
+	}
+	return s, nil
+}
+
+// SiteSyndication is the feed of the latest posts of the whole blog.
+func SiteSyndication(db *gorm.DB, number int) (*Syndication, error) {
+	db = publicPosts(db)
+	posts, err := ListPosts(db, number)
+	if err != nil {
+		return nil, err
+	}
+	return newSyndication(db, "feed/site", "Latest posts", "/posts", posts)
+}
+
+// UserSyndication is the feed of the latest posts of a user.
+func UserSyndication(db *gorm.DB, userID uint, number int) (*Syndication, error) {
+	db = publicPosts(db)
+	var u User
+	if err := db.First(&u, userID).Error; err != nil {
+		return nil, err
+	}
+
+	posts, err := GetUserLatestPosts(db, userID, number)
+	if err != nil {
+		return nil, err
+	}
+	return newSyndication(db, fmt.Sprintf("feed/user/%d", u.ID), "Posts by "+u.Name, fmt.Sprintf("/users/%d/posts", u.ID), posts)
+}
+
+// TagSyndication is the feed of the latest posts carrying the tag with the given slug.
+func TagSyndication(db *gorm.DB, slug string, number int) (*Syndication, error) {
+	db = publicPosts(db)
+	var tag Tag
+	if err := db.Where("slug = ?", slug).First(&tag).Error; err != nil {
+		return nil, err
+	}
+
+	posts, err := GetTagPosts(db, slug, number)
+	if err != nil {
+		return nil, err
+	}
+	// the ID, unlike the slug, survives a rename of the tag
+	return newSyndication(db, "feed/tag/"+strconv.FormatUint(uint64(tag.ID), 10), "Posts tagged "+tag.Name, "/tags/"+tag.Slug+"/posts", posts)
+}
+
+// published is when p went live, its creation for posts older than the lifecycle.
+func published(p *Post) time.Time {
+	if p.PublishedAt != nil {
+		return *p.PublishedAt
+	}
+	return p.CreatedAt
+}
+
+// ===== RSS 2.0 =====
+
+type rssDocument struct {
+	XMLName   xml.Name   `xml:"rss"`
+	Version   string     `xml:"version,attr"`
+	XMLNSAtom string     `xml:"xmlns:atom,attr"`
+	XMLNSDC   string     `xml:"xmlns:dc,attr"`
+	Channel   rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate"`
+	Self          atomLink  `xml:"atom:link"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+type rssItem struct {
+	Title       string   `xml:"title"`
+	Link        string   `xml:"link"`
+	GUID        rssGUID  `xml:"guid"`
+	PubDate     string   `xml:"pubDate"`
+	Creator     string   `xml:"dc:creator,omitempty"`
+	Categories  []string `xml:"category"`
+	Description string   `xml:"description"` // HTML, escaped by the encoder
+}
+
+// RSS renders the feed as RSS 2.0, baseURL is where the blog is served and self the URL of the feed itself.
+func (s *Syndication) RSS(baseURL, self string) ([]byte, error) {
+	doc := rssDocument{
+		Version:   "2.0",
+		XMLNSAtom: "http://www.w3.org/2005/Atom",
+		XMLNSDC:   "http://purl.org/dc/elements/1.1/",
+		Channel: rssChannel{
+			Title:         s.Title,
+			Link:          baseURL + s.Path,
+			Description:   s.Title,
+			LastBuildDate: s.Updated.Format(time.RFC1123Z),
+			Self:          atomLink{Href: self, Rel: "self", Type: "application/rss+xml"},
+		},
+	}
+	for i := range s.Posts {
+		p := &s.Posts[i]
+		item := rssItem{
+			Title:       p.Subject,
+			Link:        fmt.Sprintf("%s/posts/%d", baseURL, p.ID),
+			GUID:        rssGUID{Value: feedTagURI(fmt.Sprintf("post/%d", p.ID))},
+			PubDate:     published(p).Format(time.RFC1123Z),
+			Creator:     s.Authors[p.UserID],
+			Description: p.ContentHTML,
+		}
+		for _, t := range p.Tags {
+			item.Categories = append(item.Categories, t.Name)
+		}
+		doc.Channel.Items = append(doc.Channel.Items, item)
+	}
+	return marshalFeed(doc)
+}
+
+// ===== Atom 1.0 =====
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomPerson struct {
+	Name string `xml:"name"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+type atomContent struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+type atomEntry struct {
+	ID         string         `xml:"id"`
+	Title      string         `xml:"title"`
+	Updated    string         `xml:"updated"`
+	Published  string         `xml:"published"`
+	Author     atomPerson     `xml:"author"`
+	Link       atomLink       `xml:"link"`
+	Categories []atomCategory `xml:"category"`
+	Content    atomContent    `xml:"content"`
+}
+
+// Atom renders the feed as Atom 1.0, baseURL is where the blog is served and self the URL of the feed itself.
+func (s *Syndication) Atom(baseURL, self string) ([]byte, error) {
+	feed := atomFeed{
+		ID:      s.ID,
+		Title:   s.Title,
+		Updated: s.Updated.Format(time.RFC3339),
+		Links: []atomLink{
+			{Href: self, Rel: "self", Type: "application/atom+xml"},
+			{Href: baseURL + s.Path, Rel: "alternate"},
+		},
+	}
+	for i := range s.Posts {
+		p := &s.Posts[i]
+		entry := atomEntry{
+			ID:        feedTagURI(fmt.Sprintf("post/%d", p.ID)),
+			Title:     p.Subject,
+			Updated:   p.UpdatedAt.Format(time.RFC3339),
+			Published: published(p).Format(time.RFC3339),
+			Author:    atomPerson{Name: s.Authors[p.UserID]},
+			Link:      atomLink{Href: fmt.Sprintf("%s/posts/%d", baseURL, p.ID), Rel: "alternate"},
+			Content:   atomContent{Type: "html", Body: p.ContentHTML},
+		}
+		for _, t := range p.Tags {
+			entry.Categories = append(entry.Categories, atomCategory{Term: t.Name})
+		}
+		feed.Entries = append(feed.Entries, entry)
+	}
+	return marshalFeed(feed)
+}
+
+func marshalFeed(v any) ([]byte, error) {
+	body, err := xml.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), body...), nil
+}
+
+func SyndicationTest(db *gorm.DB) {
+	s, err := UserSyndication(db, 1, 2)
+	if err != nil {
+		panic(err)
+	}
+	atom, err := s.Atom("http://localhost:8080", "http://localhost:8080/users/1/atom")
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println(string(atom))
+}
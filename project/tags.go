@@ -0,0 +1,261 @@
+// Tag administration
+//
+// Every tag has a unique, URL-safe slug derived from its name ("Cloud Native" -> "cloud-native"),
+// when two names fold to the same slug the later one gets a numeric suffix ("go", "go-2").
+//
+// Merging moves the post_tags rows of the source tag to the target tag:
+//
+// INSERT INTO post_tags (post_id, tag_id)
+// SELECT post_id, <target> FROM post_tags
+// WHERE tag_id = <source> AND post_id NOT IN (SELECT post_id FROM post_tags WHERE tag_id = <target>);
+//
+// The NOT IN skips posts already carrying the target tag, (post_id, tag_id) is the primary key of post_tags.
+
+package project
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+	"gorm.io/gorm"
+)
+
+const maxSlugLen = 64
+
+type TagCount struct {
+	ID        uint
+	Name      string
+	Slug      string
+	PostCount int64
+}
+
+func (t *Tag) BeforeCreate(tx *gorm.DB) error {
+	if t.Slug != "" {
+		return nil
+	}
+
+	slug, err := uniqueSlug(tx, t.Name, 0)
+	if err != nil {
+		return err
+	}
+	t.Slug = slug
+	return nil
+}
+
+// Slugify folds name to lowercase ASCII letters and digits separated by single dashes.
+func Slugify(name string) string {
+	var b strings.Builder
+	dash := false
+	// NFD splits "é" into "e" + a combining accent, which is then dropped
+	for _, r := range norm.NFD.String(name) {
+		switch {
+		case unicode.Is(unicode.Mn, r):
+			continue
+		case r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)):
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(unicode.ToLower(r))
+			dash = false
+		default:
+			dash = true
+		}
+	}
+
+	slug := b.String()
+	if len(slug) > maxSlugLen {
+		slug = strings.TrimRight(slug[:maxSlugLen], "-")
+	}
+	if slug == "" {
+		slug = "tag"
+	}
+	return slug
+}
+
+// uniqueSlug returns the slug of name, suffixed with -2, -3, ... if another tag than excludeID already uses it.
+func uniqueSlug(tx *gorm.DB, name string, excludeID uint) (string, error) {
+	base := Slugify(name)
+
+	var taken []string
+	if err := tx.Model(&Tag{}).
+		Where("(slug = ? OR slug LIKE ?) AND id <> ?", base, base+"-%", excludeID).
+		Pluck("slug", &taken).Error; err != nil {
+		return "", err
+	}
+	used := make(map[string]bool, len(taken))
+	for _, s := range taken {
+		used[s] = true
+	}
+
+	slug := base
+	for n := 2; used[slug]; n++ {
+		suffix := fmt.Sprintf("-%d", n)
+		slug = base
+		if len(slug)+len(suffix) > maxSlugLen {
+			slug = strings.TrimRight(slug[:maxSlugLen-len(suffix)], "-")
+		}
+		slug += suffix
+	}
+	return slug, nil
+}
+
+// backfillTagSlugs gives a slug to the tags created before slugs existed.
+func backfillTagSlugs(db *gorm.DB) error {
+	var tags []Tag
+	if err := db.Where("slug IS NULL OR slug = ''").Order("id").Find(&tags).Error; err != nil {
+		return err
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, t := range tags {
+			slug, err := uniqueSlug(tx, t.Name, t.ID)
+			if err != nil {
+				return err // roll back
+			}
+			if err := tx.Model(&t).Update("slug", slug).Error; err != nil {
+				return err // roll back
+			}
+		}
+		return nil
+	})
+}
+
+// GetTagPosts returns the latest visible posts carrying the tag with the given slug.
+func GetTagPosts(db *gorm.DB, slug string, number int) ([]Post, error) {
+	var tag Tag
+	if err := db.Where("slug = ?", slug).First(&tag).Error; err != nil {
+		return nil, err
+	}
+
+	var posts []Post
+	if err := db.
+		Scopes(visiblePosts).
+		Joins("JOIN post_tags ON post_tags.post_id = posts.id").
+		Where("post_tags.tag_id = ?", tag.ID).
+		Order("posts.created_at DESC").
+		Limit(number).
+		Preload("Tags").
+		Find(&posts).Error; err != nil {
+		return nil, err
+	}
+	return posts, nil
+}
+
+// RenameTag changes the name of a tag and regenerates its slug.
+func RenameTag(db *gorm.DB, tagID uint, name string) (*Tag, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, fmt.Errorf("%w: name is required", ErrInvalidInput)
+	}
+
+	var tag Tag
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&tag, tagID).Error; err != nil {
+			return err // roll back
+		}
+
+		var n int64
+		if err := tx.Model(&Tag{}).Where("name = ? AND id <> ?", name, tagID).Count(&n).Error; err != nil {
+			return err // roll back
+		}
+		if n > 0 {
+			return fmt.Errorf("%w: tag %q already exists", gorm.ErrDuplicatedKey, name) // roll back
+		}
+
+		slug, err := uniqueSlug(tx, name, tagID)
+		if err != nil {
+			return err // roll back
+		}
+		return tx.Model(&tag).Updates(Tag{Name: name, Slug: slug}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &tag, nil
+}
+
+// MergeTags moves every post of the source tag to the target tag, then deletes the source tag.
+// It returns the number of posts that gained the target tag.
+func MergeTags(db *gorm.DB, sourceID, targetID uint) (int64, error) {
+	if sourceID == targetID {
+		return 0, fmt.Errorf("%w: cannot merge a tag into itself", ErrInvalidInput)
+	}
+
+	var moved int64
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&Tag{}, sourceID).Error; err != nil {
+			return err // roll back
+		}
+		if err := tx.First(&Tag{}, targetID).Error; err != nil {
+			return err // roll back
+		}
+
+		result := tx.Exec(`
+			INSERT INTO post_tags (post_id, tag_id)
+			SELECT post_id, ? FROM post_tags
+			WHERE tag_id = ? AND post_id NOT IN (SELECT post_id FROM post_tags WHERE tag_id = ?)
+		`, targetID, sourceID, targetID)
+		if result.Error != nil {
+			return result.Error // roll back
+		}
+		moved = result.RowsAffected
+
+		if err := tx.Where("tag_id = ?", sourceID).Delete(&postTag{}).Error; err != nil {
+			return err // roll back
+		}
+		return tx.Delete(&Tag{}, sourceID).Error
+	})
+	if err != nil {
+		return 0, err
+	}
+	return moved, nil
+}
+
+// TagCloud returns the tags with their number of published posts, most used first.
+// Tags without any published post are left out, number <= 0 means no limit.
+func TagCloud(db *gorm.DB, number int) ([]TagCount, error) {
+	var cloud []TagCount
+	tx := db.Model(&Tag{}).
+		Select("tags.id, tags.name, tags.slug, COUNT(posts.id) AS post_count").
+		Joins("JOIN post_tags ON post_tags.tag_id = tags.id").
+		Joins("JOIN posts ON posts.id = post_tags.post_id AND posts.status = ? AND posts.deleted_at IS NULL", PostPublished).
+		Group("tags.id").
+		Order("post_count DESC, tags.name")
+	if number > 0 {
+		tx = tx.Limit(number)
+	}
+	if err := tx.Scan(&cloud).Error; err != nil {
+		return nil, err
+	}
+	return cloud, nil
+}
+
+func TagAdminTest(db *gorm.DB) {
+	var t Tag
+	if err := db.Where(Tag{Name: "Cloud Native"}).FirstOrCreate(&t).Error; err != nil {
+		panic(err)
+	}
+	fmt.Println("slug:", t.Slug)
+
+	// Cloud and Cloud Native mean the same, keep Cloud
+	var cloud Tag
+	if err := db.Where("name = ?", "Cloud").First(&cloud).Error; err != nil {
+		panic(err)
+	}
+	if _, err := PublishPostWithTags(db, 2, "Kubernetes", "Operators everywhere", []uint{t.ID, cloud.ID}); err != nil {
+		panic(err)
+	}
+	moved, err := MergeTags(db, t.ID, cloud.ID)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println("posts moved to Cloud:", moved)
+
+	counts, err := TagCloud(db, 0)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println("tag cloud:", counts)
+}
@@ -0,0 +1,176 @@
+// Threaded comments
+//
+// A reply points to the comment it answers through ParentID, so a post's comments form a forest:
+//
+// 1 "Great post"             depth 0, parent NULL
+// ├── 3 "Agreed"             depth 1, parent 1
+// │   └── 4 "Me too"         depth 2, parent 3
+// └── 5 "Not sure"           depth 1, parent 1
+// 2 "Typo in line 2"         depth 0, parent NULL
+//
+// LoadCommentThread walks the whole tree in one recursive CTE instead of one query per level.
+// Each row carries the path of zero-padded IDs from its root ("0000000001.0000000003.0000000004"),
+// sorting by path yields the depth-first order above, ready to be rendered with Depth as indentation.
+
+package project
+
+import (
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+var ErrThreadTooDeep = fmt.Errorf("%w: maximum reply depth reached", ErrInvalidInput)
+
+// DeletedCommentContent replaces the content of deleted comments that still have visible replies.
+const DeletedCommentContent = "[deleted]"
+
+type ThreadComment struct {
+	Comment
+	Placeholder bool // the comment is gone but kept in the tree so its replies stay attached
+}
+
+// ReplyToComment adds a reply written by userID under the comment parentID.
+func ReplyToComment(db *gorm.DB, parentID, userID uint, content string) (*Comment, error) {
+	if strings.TrimSpace(content) == "" {
+		return nil, fmt.Errorf("%w: content is required", ErrInvalidInput)
+	}
+
+	var c Comment
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var parent Comment
+		if err := tx.First(&parent, parentID).Error; err != nil {
+			return err // roll back
+		}
+		if parent.Depth >= configFrom(tx.Statement.Context).MaxCommentDepth {
+			return ErrThreadTooDeep // roll back
+		}
+		// only under posts the commenter can see, like AddComment
+		if err := tx.Scopes(visiblePosts).First(&Post{}, parent.PostID).Error; err != nil {
+			return err // roll back
+		}
+		if err := tx.First(&User{}, userID).Error; err != nil {
+			return err // roll back
+		}
+		if err := configFrom(tx.Statement.Context).CommentRateLimit.allow(tx, actionComment, userID); err != nil {
+			return err // roll back
+		}
+
+		c = Comment{
+			PostID:   parent.PostID,
+			ParentID: &parent.ID,
+			Depth:    parent.Depth + 1,
+			UserID:   userID,
+			Content:  content,
+		}
+		if err := tx.Create(&c).Error; err != nil {
+			return err // roll back
+		}
+		// held comments are notified on approval
+		if c.Status == CommentApproved {
+			return onCommentApproved(tx, c.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// LoadCommentThread returns all comments of a post in depth-first order.
+// Deleted and unapproved comments are dropped, unless some of their replies are still visible,
+// in which case they are kept as placeholders with DeletedCommentContent or RemovedCommentContent.
+// With IncludeUnapproved in the context, pending and rejected comments are shown as they are.
+func LoadCommentThread(db *gorm.DB, postID uint) ([]ThreadComment, error) {
+	type row struct {
+		Comment
+		Path string
+	}
+
+	// deleted and unapproved rows are part of the walk, otherwise their replies would be unreachable
+	var rows []row
+	if err := db.Raw(`
+		WITH RECURSIVE thread AS (
+			SELECT c.*, printf('%010d', c.id) AS path
+			FROM comments c
+			WHERE c.post_id = ? AND c.parent_id IS NULL
+			UNION ALL
+			SELECT c.*, thread.path || '.' || printf('%010d', c.id)
+			FROM comments c
+			JOIN thread ON c.parent_id = thread.id
+		)
+		SELECT * FROM thread ORDER BY path
+	`, postID).Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	all := includesUnapproved(db.Statement.Context)
+	hidden := func(c Comment) bool {
+		return c.DeletedAt.Valid || (!all && c.Status != CommentApproved)
+	}
+
+	// children come after their parent, so walking backward sees every subtree before its root
+	visible := make([]bool, len(rows))
+	liveReplies := make(map[uint]bool)
+	for i := len(rows) - 1; i >= 0; i-- {
+		c := rows[i].Comment
+		visible[i] = !hidden(c) || liveReplies[c.ID]
+		if visible[i] && c.ParentID != nil {
+			liveReplies[*c.ParentID] = true
+		}
+	}
+
+	thread := make([]ThreadComment, 0, len(rows))
+	for i, r := range rows {
+		if !visible[i] {
+			continue
+		}
+
+		tc := ThreadComment{Comment: r.Comment}
+		if hidden(r.Comment) {
+			tc.Placeholder = true
+			tc.Content = DeletedCommentContent
+			if !r.DeletedAt.Valid {
+				tc.Content = RemovedCommentContent
+			}
+			tc.UserID = 0
+			tc.ModerationReason = ""
+		}
+		thread = append(thread, tc)
+	}
+	return thread, nil
+}
+
+func CommentThreadTest(db *gorm.DB) {
+	p, err := PublishPostWithTags(db, 1, "Threads", "Replies to replies", nil)
+	if err != nil {
+		panic(err)
+	}
+
+	root, err := AddComment(db, p.ID, 2, "Great post")
+	if err != nil {
+		panic(err)
+	}
+	reply, err := ReplyToComment(db, root.ID, 3, "Agreed")
+	if err != nil {
+		panic(err)
+	}
+	if _, err := ReplyToComment(db, reply.ID, 4, "Me too"); err != nil {
+		panic(err)
+	}
+
+	// the reply stays in the thread as a placeholder since it has a reply of its own
+	if err := SoftDeleteComment(db, reply.ID); err != nil {
+		panic(err)
+	}
+
+	thread, err := LoadCommentThread(db, p.ID)
+	if err != nil {
+		panic(err)
+	}
+	for _, c := range thread {
+		fmt.Printf("%s#%d %s\n", strings.Repeat("  ", c.Depth), c.ID, c.Content)
+	}
+}
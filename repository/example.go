@@ -0,0 +1,145 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"gorm/advanced"
+	"gorm/basis"
+	"gorm/dbtime"
+	"gorm/project"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// open returns a fresh in-memory database, the three packages each have their own users table.
+func open() *gorm.DB {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{
+		Logger:  logger.Default.LogMode(logger.Info),
+		NowFunc: dbtime.Now,
+	})
+	if err != nil {
+		panic(err)
+	}
+	// a single connection, every new connection would open another empty in-memory database
+	sqlDB, err := db.DB()
+	if err != nil {
+		panic(err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	return db
+}
+
+func RepositoryTest() {
+	ctx := context.Background()
+
+	// basis.User: CRUD and typed errors
+	db := open()
+	if err := db.AutoMigrate(&basis.User{}); err != nil {
+		panic(err)
+	}
+	users := New[basis.User](db)
+	alice := basis.User{Name: "Alice", Email: "alice@example.com", Phone: "3239085547", Age: 25}
+	if err := users.Create(ctx, &alice); err != nil {
+		panic(err)
+	}
+	err := users.Create(ctx, &basis.User{Name: "Alice 2", Email: "alice@example.com", Phone: "3239085548", Age: 25})
+	var conflict *ConflictError
+	if !errors.As(err, &conflict) || !errors.Is(err, gorm.ErrDuplicatedKey) {
+		panic(err)
+	}
+	fmt.Println(conflict)
+
+	// the mask writes Age even though it is zero, and leaves Name alone
+	alice.Name, alice.Age = "ignored", 0
+	if err := users.Update(ctx, &alice, "Age"); err != nil {
+		panic(err)
+	}
+	u, err := users.Get(ctx, alice.ID)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("after update: %s, %d\n", u.Name, u.Age)
+
+	if err := users.Delete(ctx, alice.ID); err != nil {
+		panic(err)
+	}
+	if _, err := users.Get(ctx, alice.ID); !errors.Is(err, ErrNotFound) {
+		panic(err)
+	}
+	if err := users.Delete(ctx, alice.ID); !errors.Is(err, gorm.ErrRecordNotFound) {
+		panic(err)
+	}
+
+	// advanced models: scopes and preloads
+	db = open()
+	if err := db.AutoMigrate(&advanced.User{}, &advanced.Order{}, &advanced.OrderItem{}, &advanced.Product{}); err != nil {
+		panic(err)
+	}
+	book := advanced.Product{Name: "Book", SKU: "BOOK-1", Price: 5}
+	if err := New[advanced.Product](db).Create(ctx, &book); err != nil {
+		panic(err)
+	}
+	orders := New[advanced.Order](db)
+	for i, quantity := range []uint{2, 5, 8} {
+		// Order.BeforeCreate computes TotalPrice from the items
+		o := advanced.Order{
+			OrderNumber: fmt.Sprintf("ORD-%d", i+1),
+			UserID:      1,
+			Status:      "paid",
+			Items:       []advanced.OrderItem{{ProductID: book.ID, Quantity: quantity, Price: book.Price}},
+		}
+		if err := orders.Create(ctx, &o); err != nil {
+			panic(err)
+		}
+	}
+	expensive := Where("total_price > ?", 20)
+	n, err := orders.Count(ctx, expensive)
+	if err != nil {
+		panic(err)
+	}
+	list, err := orders.List(ctx, expensive, Order("total_price DESC"), Limit(1), Preload("Items"))
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("%d orders over 20, the largest is %s with %d items\n", n, list[0].OrderNumber, len(list[0].Items))
+
+	// project models: hooks and soft delete
+	db = open()
+	if err := project.Migrate(db); err != nil {
+		panic(err)
+	}
+	if err := project.SeedBlogData(db); err != nil {
+		panic(err)
+	}
+	posts := New[project.Post](db)
+	p, err := posts.Get(ctx, 1)
+	if err != nil {
+		panic(err)
+	}
+	// Post.BeforeSave renders the new content
+	p.Content = "Now in **Markdown**"
+	if err := posts.Update(ctx, &p, "Content"); err != nil {
+		panic(err)
+	}
+	if p, err = posts.Get(ctx, 1); err != nil {
+		panic(err)
+	}
+	fmt.Printf("post %d: %s\n", p.ID, p.ContentHTML)
+
+	if err := posts.Delete(ctx, p.ID); err != nil {
+		panic(err)
+	}
+	exists, err := posts.Exists(ctx, Where("id = ?", p.ID))
+	if err != nil {
+		panic(err)
+	}
+	unscoped, err := posts.Exists(ctx, func(db *gorm.DB) *gorm.DB { return db.Unscoped() }, Where("id = ?", p.ID))
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("post %d after delete: exists %v, soft deleted row exists %v\n", p.ID, exists, unscoped)
+}
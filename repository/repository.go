@@ -0,0 +1,254 @@
+// Generic repository
+//
+// gorm.G[T] (GORM >= 1.30) is the type-safe flavour of *gorm.DB: queries return T and []T instead of
+// filling a pointer, and every terminal method takes a context.Context:
+//
+// user, err := gorm.G[User](db).Where("id = ?", 1).First(ctx)
+//
+// Repository[T] wraps it into the handful of operations most callers need, so they stop repeating
+// db.Where(...).First(&x) and matching on GORM errors:
+//
+// | Method | SQL                                                  | Errors                         |
+// | ------ | ---------------------------------------------------- | ------------------------------ |
+// | Get    | SELECT * FROM t WHERE t.id = ? ORDER BY t.id LIMIT 1 | *NotFoundError                 |
+// | List   | SELECT * FROM t WHERE <scopes> ...                   |                                |
+// | Create | INSERT INTO t ...                                    | *ConflictError                 |
+// | Update | UPDATE t SET <fields>, updated_at = ? WHERE t.id = ? | *NotFoundError, *ConflictError |
+// | Delete | DELETE FROM t WHERE t.id = ? (or SET deleted_at)     | *NotFoundError, *ConflictError |
+// | Exists | SELECT t.id FROM t WHERE <scopes> LIMIT 1            |                                |
+// | Count  | SELECT count(*) FROM t WHERE <scopes>                |                                |
+//
+// Scopes are the usual func(*gorm.DB) *gorm.DB of db.Scopes, they compose by being passed together:
+//
+// posts, err := repository.New[Post](db).List(ctx, repository.Where("user_id = ?", 1), repository.Order("id DESC"))
+//
+// Models need a single primary key for Get, Update and Delete. Hooks (BeforeSave, ...) and soft delete
+// behave as with *gorm.DB. Inside a transaction, build the repository on the tx: repository.New[Post](tx).
+
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+var (
+	ErrNotFound = errors.New("not found")
+	ErrConflict = errors.New("conflict")
+)
+
+// NotFoundError reports a missing row, it matches ErrNotFound and gorm.ErrRecordNotFound.
+type NotFoundError struct {
+	Model string
+	Key   any // primary key, nil when looked up by scopes
+}
+
+func (e *NotFoundError) Error() string {
+	if e.Key == nil {
+		return fmt.Sprintf("%s not found", e.Model)
+	}
+	return fmt.Sprintf("%s %v not found", e.Model, e.Key)
+}
+
+func (e *NotFoundError) Is(target error) bool {
+	return target == ErrNotFound || target == gorm.ErrRecordNotFound
+}
+
+// ConflictError reports a write rejected by a unique or foreign key constraint, it matches ErrConflict
+// and wraps the translated GORM error (gorm.ErrDuplicatedKey or gorm.ErrForeignKeyViolated).
+type ConflictError struct {
+	Model string
+	Err   error
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("%s conflict: %v", e.Model, e.Err)
+}
+
+func (e *ConflictError) Is(target error) bool {
+	return target == ErrConflict
+}
+
+func (e *ConflictError) Unwrap() error {
+	return e.Err
+}
+
+// Scope narrows a query, like the functions given to db.Scopes.
+type Scope func(*gorm.DB) *gorm.DB
+
+func Where(query any, args ...any) Scope {
+	return func(db *gorm.DB) *gorm.DB { return db.Where(query, args...) }
+}
+
+func Order(value any) Scope {
+	return func(db *gorm.DB) *gorm.DB { return db.Order(value) }
+}
+
+func Limit(n int) Scope {
+	return func(db *gorm.DB) *gorm.DB { return db.Limit(n) }
+}
+
+func Offset(n int) Scope {
+	return func(db *gorm.DB) *gorm.DB { return db.Offset(n) }
+}
+
+func Preload(association string, args ...any) Scope {
+	return func(db *gorm.DB) *gorm.DB { return db.Preload(association, args...) }
+}
+
+type Repository[T any] struct {
+	db *gorm.DB
+}
+
+func New[T any](db *gorm.DB) *Repository[T] {
+	return &Repository[T]{db: db}
+}
+
+// query applies the scopes on the statement of a gorm.G query. The statement is a fresh instance,
+// scopes add their clauses to it in place.
+func (r *Repository[T]) query(scopes []Scope) gorm.ChainInterface[T] {
+	return gorm.G[T](r.db).Scopes(func(stmt *gorm.Statement) {
+		for _, scope := range scopes {
+			scope(stmt.DB)
+		}
+	})
+}
+
+// schema parses T, cached by GORM after the first call.
+func (r *Repository[T]) schema() (*schema.Schema, error) {
+	stmt := &gorm.Statement{DB: r.db}
+	if err := stmt.Parse(new(T)); err != nil {
+		return nil, err
+	}
+	return stmt.Schema, nil
+}
+
+// primaryKey returns the condition on the single primary key of T.
+func (r *Repository[T]) primaryKey(id any) (clause.Expression, *schema.Schema, error) {
+	s, err := r.schema()
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(s.PrimaryFields) != 1 {
+		return nil, s, fmt.Errorf("repository: %s needs a single primary key, it has %d", s.Name, len(s.PrimaryFields))
+	}
+	col := clause.Column{Table: clause.CurrentTable, Name: s.PrioritizedPrimaryField.DBName}
+	return clause.Eq{Column: col, Value: id}, s, nil
+}
+
+// translate turns the driver errors into gorm.ErrDuplicatedKey and gorm.ErrForeignKeyViolated
+// (which GORM only does by itself when opened with TranslateError) and those into a *ConflictError.
+func (r *Repository[T]) translate(err error) error {
+	if err == nil {
+		return nil
+	}
+	if t, ok := r.db.Dialector.(gorm.ErrorTranslator); ok {
+		err = t.Translate(err)
+	}
+	if errors.Is(err, gorm.ErrDuplicatedKey) || errors.Is(err, gorm.ErrForeignKeyViolated) {
+		s, _ := r.schema()
+		name := "record"
+		if s != nil {
+			name = s.Name
+		}
+		return &ConflictError{Model: name, Err: err}
+	}
+	return err
+}
+
+// Get loads the row with the primary key id.
+func (r *Repository[T]) Get(ctx context.Context, id any, scopes ...Scope) (T, error) {
+	pk, s, err := r.primaryKey(id)
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	v, err := r.query(scopes).Where(pk).First(ctx)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return v, &NotFoundError{Model: s.Name, Key: id}
+	}
+	return v, err
+}
+
+// List returns the rows matching the scopes, in the order they set.
+func (r *Repository[T]) List(ctx context.Context, scopes ...Scope) ([]T, error) {
+	return r.query(scopes).Find(ctx)
+}
+
+// Create inserts v and fills in its primary key and defaults.
+func (r *Repository[T]) Create(ctx context.Context, v *T) error {
+	return r.translate(gorm.G[T](r.db).Create(ctx, v))
+}
+
+// Update writes the given fields of v (Go field names or columns) to its row, and nothing else:
+// zero values are written too, unlike Updates with a struct. It fails with ErrMissingWhereClause
+// if v has no primary key.
+//
+// It goes through db.Model(v) rather than gorm.G, whose Updates takes T by value: BeforeSave and
+// BeforeUpdate hooks with a pointer receiver, and the fields they set, need the pointer.
+func (r *Repository[T]) Update(ctx context.Context, v *T, fields ...string) error {
+	if len(fields) == 0 {
+		return errors.New("repository: update needs at least one field")
+	}
+	s, err := r.schema()
+	if err != nil {
+		return err
+	}
+
+	result := r.db.WithContext(ctx).Model(v).Select(fields).Updates(v)
+	if err := r.translate(result.Error); err != nil {
+		return err
+	}
+	if result.RowsAffected == 0 {
+		var key any
+		if pk := s.PrioritizedPrimaryField; pk != nil {
+			key, _ = pk.ValueOf(ctx, reflect.ValueOf(v).Elem())
+		}
+		return &NotFoundError{Model: s.Name, Key: key}
+	}
+	return nil
+}
+
+// Delete deletes the row with the primary key id, soft deleting it if T has a gorm.DeletedAt.
+func (r *Repository[T]) Delete(ctx context.Context, id any) error {
+	pk, s, err := r.primaryKey(id)
+	if err != nil {
+		return err
+	}
+	n, err := gorm.G[T](r.db).Where(pk).Delete(ctx)
+	if err := r.translate(err); err != nil {
+		return err
+	}
+	if n == 0 {
+		return &NotFoundError{Model: s.Name, Key: id}
+	}
+	return nil
+}
+
+// Exists reports whether a row matches the scopes.
+func (r *Repository[T]) Exists(ctx context.Context, scopes ...Scope) (bool, error) {
+	s, err := r.schema()
+	if err != nil {
+		return false, err
+	}
+	q := r.query(scopes)
+	if s.PrioritizedPrimaryField != nil {
+		q = q.Select(s.Table + "." + s.PrioritizedPrimaryField.DBName)
+	}
+	_, err = q.Take(ctx)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Count counts the rows matching the scopes.
+func (r *Repository[T]) Count(ctx context.Context, scopes ...Scope) (int64, error) {
+	return r.query(scopes).Count(ctx, "*")
+}
@@ -0,0 +1,152 @@
+// Retention purge
+//
+// Soft deleted rows are only marked with deleted_at and stay in their table forever.
+// Purge hard deletes (Unscoped().Delete) the ones deleted before a cutoff:
+//
+// SELECT id FROM comments WHERE deleted_at IS NOT NULL AND deleted_at < ? ORDER BY id LIMIT 500;
+// DELETE FROM comments WHERE id IN (...) AND deleted_at < ?;
+//
+// SQLite has a single writer, a DELETE of a million rows would lock out every other write until it commits.
+// So rows are deleted in batches of BatchSize, each in its own short transaction, with an optional
+// pause in between to let other writers through. The SELECT and the DELETE of a batch share the transaction
+// and the DELETE repeats every condition, deleted_at and the Scope of the target, so a row that stopped
+// qualifying in between (restored, or given a reply) survives.
+
+package retention
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type Target struct {
+	Model any // pointer to a model with a gorm.DeletedAt field and an integer primary key
+
+	// Scope optionally adds a condition, e.g. to keep rows still referenced by others.
+	// It gets the cutoff of the purge, for conditions on when other rows were deleted.
+	Scope func(db *gorm.DB, cutoff time.Time) *gorm.DB
+
+	// AfterDelete optionally removes what referenced the rows of a batch, in the transaction of its DELETE.
+	// Some of ids may have been kept by the DELETE, only remove what points to rows that are gone.
+	AfterDelete func(tx *gorm.DB, ids []uint64) error
+}
+
+type Options struct {
+	Days      int           // purge rows soft deleted more than Days days ago
+	BatchSize int           // rows per DELETE, zero means 500
+	Pause     time.Duration // sleep between two batches
+	DryRun    bool          // only count the rows that would be purged
+}
+
+// Result reports the purge of one model.
+type Result struct {
+	Table   string
+	Matched int64 // rows older than the cutoff, counted before purging
+	Deleted int64 // rows actually deleted, 0 in a dry run
+	Batches int
+}
+
+type Report struct {
+	Cutoff  time.Time
+	DryRun  bool
+	Results []Result
+}
+
+// Purge hard deletes the rows of every target soft deleted before now - opts.Days.
+// Targets are purged one after the other, a failure stops the purge and returns the report so far.
+func Purge(db *gorm.DB, opts Options, targets ...Target) (*Report, error) {
+	if opts.Days < 0 {
+		return nil, fmt.Errorf("retention: days must not be negative, got %d", opts.Days)
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 500
+	}
+
+	report := &Report{
+		Cutoff: db.NowFunc().AddDate(0, 0, -opts.Days),
+		DryRun: opts.DryRun,
+	}
+	for _, t := range targets {
+		res, err := purge(db, t, report.Cutoff, opts)
+		report.Results = append(report.Results, res)
+		if err != nil {
+			return report, fmt.Errorf("retention: %s: %w", res.Table, err)
+		}
+	}
+	return report, nil
+}
+
+func purge(db *gorm.DB, t Target, cutoff time.Time, opts Options) (Result, error) {
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(t.Model); err != nil {
+		return Result{}, err
+	}
+	res := Result{Table: stmt.Schema.Table}
+
+	deletedAt := stmt.Schema.LookUpField("DeletedAt")
+	pk := stmt.Schema.PrioritizedPrimaryField
+	if deletedAt == nil || pk == nil {
+		return res, errors.New("needs a DeletedAt field and a single primary key")
+	}
+
+	// purgeable rows, rebuilt for every query
+	expired := func(tx *gorm.DB) *gorm.DB {
+		tx = tx.Unscoped().Model(t.Model).
+			Where(fmt.Sprintf("%s IS NOT NULL AND %s < ?", deletedAt.DBName, deletedAt.DBName), cutoff)
+		if t.Scope != nil {
+			tx = t.Scope(tx, cutoff)
+		}
+		return tx
+	}
+
+	if err := expired(db).Count(&res.Matched).Error; err != nil {
+		return res, err
+	}
+	if opts.DryRun || res.Matched == 0 {
+		return res, nil
+	}
+
+	for {
+		if err := db.Statement.Context.Err(); err != nil {
+			return res, err
+		}
+
+		var ids []uint64
+		var deleted int64
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := expired(tx).Order(pk.DBName).Limit(opts.BatchSize).Pluck(pk.DBName, &ids).Error; err != nil {
+				return err // roll back
+			}
+			if len(ids) == 0 {
+				return nil
+			}
+
+			result := expired(tx).Where(fmt.Sprintf("%s IN ?", pk.DBName), ids).Delete(t.Model)
+			if result.Error != nil {
+				return result.Error // roll back
+			}
+			deleted = result.RowsAffected
+			if t.AfterDelete != nil {
+				return t.AfterDelete(tx, ids)
+			}
+			return nil
+		})
+		if err != nil {
+			return res, err
+		}
+		// stop on an empty batch rather than a short one: deleting a batch can make more rows
+		// purgeable when Scope depends on other rows (a comment whose last reply was just purged)
+		if len(ids) == 0 {
+			return res, nil
+		}
+		res.Deleted += deleted
+		res.Batches++
+
+		if opts.Pause > 0 {
+			time.Sleep(opts.Pause)
+		}
+	}
+}
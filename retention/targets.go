@@ -0,0 +1,72 @@
+package retention
+
+import (
+	"fmt"
+	"time"
+
+	"gorm/advanced"
+	"gorm/dbtime"
+	"gorm/project"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// Comments purges soft deleted blog comments. A comment is kept while replies still point to it,
+// deleted or not, so the thread of a live reply never loses its parent; it goes once its replies are purged.
+// Comments deleted by the cascade of project.DeletePost come back with project.RestorePost, they are kept
+// until their post has been deleted for longer than the retention, whatever their own deleted_at.
+var Comments = Target{
+	Model: &project.Comment{},
+	Scope: func(db *gorm.DB, cutoff time.Time) *gorm.DB {
+		return db.Where("NOT EXISTS (SELECT 1 FROM comments AS replies WHERE replies.parent_id = comments.id)").
+			Where(`(comments.deleted_with_post = ? OR NOT EXISTS (
+				SELECT 1 FROM posts WHERE posts.id = comments.post_id AND (posts.deleted_at IS NULL OR posts.deleted_at >= ?)
+			))`, false, cutoff)
+	},
+	// the reactions to purged comments would never be found again
+	AfterDelete: func(tx *gorm.DB, ids []uint64) error {
+		return tx.Where("target_type = ? AND target_id IN ?", project.ReactionOnComment, ids).
+			Where("NOT EXISTS (SELECT 1 FROM comments WHERE comments.id = reactions.target_id)").
+			Delete(&project.Reaction{}).Error
+	},
+}
+
+// SoftDeletedOrders purges the orders of advanced.SoftDeleteTest.
+var SoftDeletedOrders = Target{
+	Model: &advanced.OrderWithSoftDelete{},
+}
+
+func RetentionTest() {
+	for _, run := range []struct {
+		dsn    string
+		target Target
+	}{
+		{"db/blog.db", Comments},
+		{"db/soft_delete.db", SoftDeletedOrders},
+	} {
+		dsn, target := run.dsn, run.target
+		db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{
+			Logger:  logger.Default.LogMode(logger.Info),
+			NowFunc: dbtime.Now,
+		})
+		if err != nil {
+			panic(err)
+		}
+
+		// dry run first: how many rows deleted over a week ago would go
+		report, err := Purge(db, Options{Days: 7, DryRun: true}, target)
+		if err != nil {
+			panic(err)
+		}
+		fmt.Printf("%s: %+v\n", dsn, report.Results)
+
+		// everything soft deleted before now, 100 rows at a time
+		report, err = Purge(db, Options{Days: 0, BatchSize: 100}, target)
+		if err != nil {
+			panic(err)
+		}
+		fmt.Printf("%s: %+v\n", dsn, report.Results)
+	}
+}
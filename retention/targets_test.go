@@ -0,0 +1,112 @@
+package retention
+
+import (
+	"testing"
+
+	"gorm/dbtime"
+	"gorm/project"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// openBlogDB returns a migrated in-memory blog database with two users.
+func openBlogDB(t *testing.T) (*gorm.DB, []uint) {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{
+		Logger:  logger.Default.LogMode(logger.Silent),
+		NowFunc: dbtime.Now,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a single connection, every new connection would open another empty in-memory database
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+
+	if err := project.Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	seeded, err := project.Seed(db, project.SeedOptions{Seed: 1, Users: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db, seeded.UserIDs
+}
+
+func TestPurgeCascadedComments(t *testing.T) {
+	tests := []struct {
+		name            string
+		postDeletedDays int // days since the post was deleted, the retention is 7
+		wantKept        bool
+	}{
+		{"post deleted within the retention", 3, true},
+		{"post deleted past the retention", 30, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, users := openBlogDB(t)
+			p, err := project.PublishPostWithTags(db, users[0], "Gone", "With its comments", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			c, err := project.AddComment(db, p.ID, users[1], "First")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := project.ReplyToComment(db, c.ID, users[0], "Reply"); err != nil {
+				t.Fatal(err)
+			}
+			if err := project.React(db, users[0], project.ReactionOnComment, c.ID, project.ReactionLike); err != nil {
+				t.Fatal(err)
+			}
+			if err := project.DeletePost(db, p.ID); err != nil {
+				t.Fatal(err)
+			}
+
+			// the comments themselves are past the retention, only the post decides
+			now := db.NowFunc()
+			if err := db.Unscoped().Model(&project.Comment{}).Where("post_id = ?", p.ID).
+				UpdateColumn("deleted_at", now.AddDate(0, 0, -40)).Error; err != nil {
+				t.Fatal(err)
+			}
+			if err := db.Unscoped().Model(&project.Post{}).Where("id = ?", p.ID).
+				UpdateColumn("deleted_at", now.AddDate(0, 0, -tt.postDeletedDays)).Error; err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := Purge(db, Options{Days: 7, BatchSize: 1}, Comments); err != nil {
+				t.Fatal(err)
+			}
+
+			var comments, reactions int64
+			db.Unscoped().Model(&project.Comment{}).Where("post_id = ?", p.ID).Count(&comments)
+			db.Model(&project.Reaction{}).Where("target_type = ? AND target_id = ?", project.ReactionOnComment, c.ID).Count(&reactions)
+			if (comments == 2) != tt.wantKept || (comments == 0) == tt.wantKept {
+				t.Fatalf("got %d of the 2 comments, want kept %v", comments, tt.wantKept)
+			}
+			if (reactions == 1) != tt.wantKept {
+				t.Fatalf("got %d reactions to the comment, want kept %v", reactions, tt.wantKept)
+			}
+			if !tt.wantKept {
+				return
+			}
+
+			// kept comments come back with their post
+			if err := project.RestorePost(db, p.ID); err != nil {
+				t.Fatal(err)
+			}
+			if err := db.Model(&project.Comment{}).Where("post_id = ?", p.ID).Count(&comments).Error; err != nil {
+				t.Fatal(err)
+			}
+			if comments != 2 {
+				t.Fatalf("got %d comments after RestorePost, want 2", comments)
+			}
+		})
+	}
+}
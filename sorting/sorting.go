@@ -0,0 +1,232 @@
+// Dynamic sorting
+//
+// A sort spec lists the sort keys of a query, most significant first, e.g. ?sort=-age,name:
+//
+// | Key                       | ORDER BY                            |
+// | ------------------------- | ----------------------------------- |
+// | name, +name               | users.name                          |
+// | -age                      | users.age DESC                      |
+// | -last_login_at:nulls_last | users.last_login_at DESC NULLS LAST |
+// | orders.created_at         | orders.created_at                   |
+//
+// The primary key is appended as the last key, unless the spec already sorts on it, so rows with equal
+// keys always come in the same order and pages don't overlap:
+//
+// SELECT * FROM users ORDER BY users.age DESC, users.name, users.id;
+//
+// Passing a user-supplied string to db.Order is an SQL injection, whatever follows ORDER BY is run as is.
+// Here every key must be a column of the model, or of one of its associations prefixed with the association's
+// table, and is quoted as an identifier. An association column only sorts a query that joins that table
+// under its own name, as in advanced.join4SortingTest:
+//
+// db.Joins("LEFT JOIN orders ON orders.user_id = users.id").Scopes(sortScope)
+//
+// Errors are *SyntaxError, with the position of the offending key in the spec.
+
+package sorting
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+var ErrInvalidSort = errors.New("invalid sort")
+
+// Nulls places the NULLs of a key, the database default (first ascending in SQLite) when unset.
+type Nulls string
+
+const (
+	NullsFirst Nulls = "nulls_first"
+	NullsLast  Nulls = "nulls_last"
+)
+
+// SyntaxError reports the key of a spec that can't be parsed or isn't sortable, it matches ErrInvalidSort.
+type SyntaxError struct {
+	Pos   int    // byte offset of Token in the spec
+	Token string // offending key
+	Msg   string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("sort: %s at position %d: %q", e.Msg, e.Pos, e.Token)
+}
+
+func (e *SyntaxError) Unwrap() error {
+	return ErrInvalidSort
+}
+
+// Key is a parsed sort key.
+type Key struct {
+	Table  string // of the model or of an association
+	Column string
+	Desc   bool
+	Nulls  Nulls
+}
+
+// Sorter resolves the sort keys of a model.
+type Sorter struct {
+	schema *schema.Schema
+	only   map[string]bool // nil allows every column
+}
+
+// New derives the sortable columns from model and its associations. When only is given, the keys are
+// restricted to those (as written in specs, e.g. "name" or "orders.created_at"), to keep columns nobody
+// should sort on out of reach.
+func New(db *gorm.DB, model any, only ...string) (*Sorter, error) {
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(model); err != nil {
+		return nil, err
+	}
+
+	s := &Sorter{schema: stmt.Schema}
+	if len(only) > 0 {
+		s.only = make(map[string]bool, len(only))
+		for _, name := range only {
+			if _, _, ok := s.lookUp(name); !ok {
+				return nil, fmt.Errorf("sort: %s has no column %q", stmt.Schema.Name, name)
+			}
+			s.only[name] = true
+		}
+	}
+	return s, nil
+}
+
+// lookUp resolves "column" or "table.column" to the table and column to sort on.
+func (s *Sorter) lookUp(name string) (table, column string, ok bool) {
+	sch := s.schema
+	if prefix, col, found := strings.Cut(name, "."); found {
+		sch = nil
+		if prefix == s.schema.Table {
+			sch = s.schema
+		}
+		for _, rel := range s.schema.Relationships.Relations {
+			if rel.FieldSchema.Table == prefix {
+				sch = rel.FieldSchema
+				break
+			}
+		}
+		if sch == nil {
+			return "", "", false
+		}
+		name = col
+	}
+
+	// column names only, Go field names would let specs depend on the struct
+	field := sch.LookUpField(name)
+	if field == nil || field.DBName != name || !field.Readable {
+		return "", "", false
+	}
+	return sch.Table, field.DBName, true
+}
+
+// Parse parses a spec into its keys, the primary key tiebreak included.
+func (s *Sorter) Parse(spec string) ([]Key, error) {
+	var keys []Key
+	seen := make(map[string]bool)
+	for pos := 0; pos <= len(spec); {
+		end := strings.IndexByte(spec[pos:], ',')
+		if end < 0 {
+			end = len(spec)
+		} else {
+			end += pos
+		}
+		raw := spec[pos:end]
+		// spaces after commas are tolerated, "-age, name"
+		text := strings.TrimSpace(raw)
+		at := pos + strings.Index(raw, text)
+		pos = end + 1
+		if text == "" {
+			if len(keys) == 0 && end == len(spec) {
+				break // empty spec
+			}
+			return nil, &SyntaxError{Pos: at, Token: raw, Msg: "empty key"}
+		}
+
+		key, err := s.parseKey(text)
+		if err != nil {
+			return nil, &SyntaxError{Pos: at, Token: text, Msg: err.Error()}
+		}
+		qualified := key.Table + "." + key.Column
+		if seen[qualified] {
+			return nil, &SyntaxError{Pos: at, Token: text, Msg: "duplicate key"}
+		}
+		seen[qualified] = true
+		keys = append(keys, key)
+	}
+
+	for _, pk := range s.schema.PrimaryFields {
+		if !seen[s.schema.Table+"."+pk.DBName] {
+			keys = append(keys, Key{Table: s.schema.Table, Column: pk.DBName})
+		}
+	}
+	return keys, nil
+}
+
+func (s *Sorter) parseKey(text string) (Key, error) {
+	var key Key
+	name, nulls, hasNulls := strings.Cut(text, ":")
+	if hasNulls {
+		switch Nulls(nulls) {
+		case NullsFirst, NullsLast:
+			key.Nulls = Nulls(nulls)
+		default:
+			return key, fmt.Errorf("expected %s or %s after :", NullsFirst, NullsLast)
+		}
+	}
+	if strings.HasPrefix(name, "-") {
+		key.Desc, name = true, name[1:]
+	} else {
+		name = strings.TrimPrefix(name, "+")
+	}
+
+	if s.only != nil && !s.only[name] {
+		return key, fmt.Errorf("can't sort on %q", name)
+	}
+	table, column, ok := s.lookUp(name)
+	if !ok {
+		return key, fmt.Errorf("can't sort on %q", name)
+	}
+	key.Table, key.Column = table, column
+	return key, nil
+}
+
+// OrderBy compiles spec into an ORDER BY clause, for db.Order.
+func (s *Sorter) OrderBy(spec string) (clause.OrderBy, error) {
+	keys, err := s.Parse(spec)
+	if err != nil {
+		return clause.OrderBy{}, err
+	}
+
+	sql := make([]string, len(keys))
+	vars := make([]any, len(keys))
+	for i, k := range keys {
+		sql[i] = "?"
+		if k.Desc {
+			sql[i] += " DESC"
+		}
+		switch k.Nulls {
+		case NullsFirst:
+			sql[i] += " NULLS FIRST"
+		case NullsLast:
+			sql[i] += " NULLS LAST"
+		}
+		vars[i] = clause.Column{Table: k.Table, Name: k.Column}
+	}
+	return clause.OrderBy{Expression: clause.Expr{SQL: strings.Join(sql, ", "), Vars: vars}}, nil
+}
+
+// Scope compiles spec into a scope ordering the query, an empty spec sorts on the primary key.
+func (s *Sorter) Scope(spec string) (func(*gorm.DB) *gorm.DB, error) {
+	order, err := s.OrderBy(spec)
+	if err != nil {
+		return nil, err
+	}
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Order(order)
+	}, nil
+}
@@ -0,0 +1,159 @@
+package sorting
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+type customer struct {
+	ID          uint
+	Name        string
+	Age         int
+	LastLoginAt *time.Time
+	Password    string
+	Orders      []order
+}
+
+type order struct {
+	ID         uint
+	CustomerID uint
+	Total      float64
+	CreatedAt  time.Time
+}
+
+func openTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestParse(t *testing.T) {
+	s, err := New(openTestDB(t), &customer{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		spec string
+		want []Key
+	}{
+		{"", []Key{{Table: "customers", Column: "id"}}},
+		{"name", []Key{{Table: "customers", Column: "name"}, {Table: "customers", Column: "id"}}},
+		{"+name,-age", []Key{
+			{Table: "customers", Column: "name"},
+			{Table: "customers", Column: "age", Desc: true},
+			{Table: "customers", Column: "id"},
+		}},
+		{"-last_login_at:nulls_last, name", []Key{
+			{Table: "customers", Column: "last_login_at", Desc: true, Nulls: NullsLast},
+			{Table: "customers", Column: "name"},
+			{Table: "customers", Column: "id"},
+		}},
+		// no tiebreak when the spec already sorts on the primary key
+		{"-id", []Key{{Table: "customers", Column: "id", Desc: true}}},
+		{"age,customers.id", []Key{{Table: "customers", Column: "age"}, {Table: "customers", Column: "id"}}},
+		{"-orders.created_at", []Key{{Table: "orders", Column: "created_at", Desc: true}, {Table: "customers", Column: "id"}}},
+	}
+	for _, tt := range tests {
+		got, err := s.Parse(tt.spec)
+		if err != nil {
+			t.Errorf("%q: %v", tt.spec, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: got %+v, want %+v", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestParseRejects(t *testing.T) {
+	db := openTestDB(t)
+	all, err := New(db, &customer{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	only, err := New(db, &customer{}, "name", "age")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		sorter *Sorter
+		spec   string
+		pos    int
+		token  string
+		msg    string
+	}{
+		// injections never reach the SQL, they aren't columns
+		{all, "name; DROP TABLE customers", 0, "name; DROP TABLE customers", `can't sort on "name; DROP TABLE customers"`},
+		{all, "name DESC", 0, "name DESC", `can't sort on "name DESC"`},
+		{all, "age,(SELECT 1)", 4, "(SELECT 1)", `can't sort on "(SELECT 1)"`},
+		{all, "-id--", 0, "-id--", `can't sort on "id--"`},
+		// unknown columns, Go field names and tables
+		{all, "email", 0, "email", `can't sort on "email"`},
+		{all, "Name", 0, "Name", `can't sort on "Name"`},
+		{all, "users.name", 0, "users.name", `can't sort on "users.name"`},
+		// whitelist
+		{only, "password", 0, "password", `can't sort on "password"`},
+		{only, "name,-id", 5, "-id", `can't sort on "id"`},
+		// malformed specs
+		{all, "name,,age", 5, "", "empty key"},
+		{all, "name,", 5, "", "empty key"},
+		{all, "name, name", 6, "name", "duplicate key"},
+		{all, "name:nulls_middle", 0, "name:nulls_middle", "expected nulls_first or nulls_last after :"},
+	}
+	for _, tt := range tests {
+		_, err := tt.sorter.Parse(tt.spec)
+		if !errors.Is(err, ErrInvalidSort) {
+			t.Errorf("%q: got %v, want ErrInvalidSort", tt.spec, err)
+			continue
+		}
+		var serr *SyntaxError
+		if !errors.As(err, &serr) {
+			t.Errorf("%q: got %T, want *SyntaxError", tt.spec, err)
+			continue
+		}
+		if serr.Pos != tt.pos || serr.Token != tt.token || serr.Msg != tt.msg {
+			t.Errorf("%q: got %q at %d (%q), want %q at %d (%q)", tt.spec, serr.Msg, serr.Pos, serr.Token, tt.msg, tt.pos, tt.token)
+		}
+	}
+
+	if _, err := New(db, &customer{}, "email"); err == nil {
+		t.Error("New with an unknown column in only: got no error")
+	}
+}
+
+func TestScopeSQL(t *testing.T) {
+	db := openTestDB(t)
+	s, err := New(db, &customer{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		spec string
+		want string
+	}{
+		{"", "SELECT * FROM `customers` ORDER BY `customers`.`id`"},
+		{"-age,name", "SELECT * FROM `customers` ORDER BY `customers`.`age` DESC, `customers`.`name`, `customers`.`id`"},
+		{"last_login_at:nulls_first", "SELECT * FROM `customers` ORDER BY `customers`.`last_login_at` NULLS FIRST, `customers`.`id`"},
+		{"-last_login_at:nulls_last", "SELECT * FROM `customers` ORDER BY `customers`.`last_login_at` DESC NULLS LAST, `customers`.`id`"},
+	}
+	for _, tt := range tests {
+		scope, err := s.Scope(tt.spec)
+		if err != nil {
+			t.Errorf("%q: %v", tt.spec, err)
+			continue
+		}
+		got := db.ToSQL(func(tx *gorm.DB) *gorm.DB { return tx.Scopes(scope).Find(&[]customer{}) })
+		if got != tt.want {
+			t.Errorf("%q:\n got %s\nwant %s", tt.spec, got, tt.want)
+		}
+	}
+}